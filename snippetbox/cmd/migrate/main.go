@@ -0,0 +1,119 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+
+	"snippetbox.lets-go/internal/dialect"
+	"snippetbox.lets-go/internal/migrate"
+	"snippetbox.lets-go/migrations"
+)
+
+const usage = `usage: migrate [flags] <command>
+
+commands:
+  up           apply all pending migrations
+  down [n]     revert the last n migrations (default 1)
+  status       list migrations and whether they have been applied
+  force <v>    set the schema version to v and clear the dirty flag
+
+flags:
+`
+
+func main() {
+
+	// define cmd line args
+	dbDriver := flag.String("db-driver", "mysql", "Database driver: mysql, postgres or sqlite")
+	dsn := flag.String("dsn", "", "Data Source Name. for mysql it should be in the form web:pass@/snippetbox?parseTime=true, for sqlite a file name like snippetbox.db")
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// create loggers for appropriate messages
+	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime)
+
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	d, err := dialect.Get(*dbDriver)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	db, err := dialect.Open(d, *dsn)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	defer db.Close()
+
+	files, err := migrations.For(d)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	m, err := migrate.New(db, d, files)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	switch cmd := flag.Arg(0); cmd {
+	case "up":
+		n, err := m.Up()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("applied %d migration(s)", n)
+
+	case "down":
+		steps := 1
+		if flag.NArg() > 1 {
+			steps, err = strconv.Atoi(flag.Arg(1))
+			if err != nil || steps < 1 {
+				errorLog.Fatalf("invalid number of steps %q", flag.Arg(1))
+			}
+		}
+		n, err := m.Down(steps)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("reverted %d migration(s)", n)
+
+	case "status":
+		statuses, version, dirty, err := m.Status()
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		fmt.Printf("version: %d (dirty: %t)\n", version, dirty)
+		for _, s := range statuses {
+			state := "pending"
+			if s.Applied {
+				state = "applied"
+			}
+			fmt.Printf("%04d  %-8s %s\n", s.Version, state, s.Name)
+		}
+
+	case "force":
+		if flag.NArg() < 2 {
+			errorLog.Fatal("force needs a version")
+		}
+		version, err := strconv.Atoi(flag.Arg(1))
+		if err != nil || version < 0 {
+			errorLog.Fatalf("invalid version %q", flag.Arg(1))
+		}
+		if err := m.Force(version); err != nil {
+			errorLog.Fatal(err)
+		}
+		infoLog.Printf("forced version %d", version)
+
+	default:
+		errorLog.Printf("unknown command %q", cmd)
+		flag.Usage()
+		os.Exit(2)
+	}
+}
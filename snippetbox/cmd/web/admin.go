@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/julienschmidt/httprouter"
+	"snippetbox.lets-go/internal/models"
+)
+
+// how many days of signups and new snippets the admin dashboard shows
+const adminDashboardDays = 30
+
+// handler for the admin dashboard, with the totals and the activity over the last month
+func (app *application) adminDashboard(w http.ResponseWriter, r *http.Request) {
+	stats, err := app.admin.Stats()
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	now := app.now()
+	counts, err := app.admin.DailyCounts(now.AddDate(0, 0, 1-adminDashboardDays), now)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// the bars are all drawn against the busiest day. a <meter> with a max of zero is
+	// drawn full, so we never go below one
+	max := 1
+	for _, c := range counts {
+		if c.Signups > max {
+			max = c.Signups
+		}
+		if c.Snippets > max {
+			max = c.Snippets
+		}
+	}
+
+	data := app.newTemplateData(r)
+	data.AdminStats = stats
+	data.DailyCounts = counts
+	data.DailyMax = max
+	app.render(w, r, http.StatusOK, "admin.tmpl.html", data)
+}
+
+// handler for the list of every user, which can be searched by name or email address
+func (app *application) adminUsers(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	page, err := pageNumber(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	users, more, err := app.admin.Users(query, page)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Query = query
+	data.Users = users
+	data.Pagination = newPagePagination("/admin/users", url.Values{"q": {query}}, page, more)
+	app.render(w, r, http.StatusOK, "admin_users.tmpl.html", data)
+}
+
+// handler to disable a user, which logs them out everywhere
+func (app *application) adminUserDisablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetUserDisabled(w, r, true)
+}
+
+// handler to let a disabled user log in again
+func (app *application) adminUserEnablePost(w http.ResponseWriter, r *http.Request) {
+	app.adminSetUserDisabled(w, r, false)
+}
+
+// disable or re-enable the user whose id is in the URL
+func (app *application) adminSetUserDisabled(w http.ResponseWriter, r *http.Request, disabled bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// an admin who disabled themselves would be locked out with nobody to let them back in
+	if disabled && id == app.authenticatedUserID(r) {
+		app.sessionManager.Put(r.Context(), "flash", "you can't disable your own account")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.admin.SetUserDisabled(id, disabled)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	flash := "the user has been enabled"
+	if disabled {
+		// authenticate() would log them out on their next request anyway, but there's no
+		// point keeping the sessions around
+		_, err = app.userSessions.DeleteAllExcept(id, "")
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// their API tokens go too. apiAuthenticate() refuses them while the user is disabled,
+		// but otherwise enabling the user again would bring back every token they had, which
+		// might be why they were disabled in the first place
+		_, err = app.tokens.DeleteAll(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		flash = "the user has been disabled, and their sessions and API tokens revoked"
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", flash)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// struct to represent the form for changing a user's role
+type adminUserRoleForm struct {
+	Role string `form:"role"`
+}
+
+// handler to change the role of the user whose id is in the URL to the one in the form
+func (app *application) adminUserRolePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	var form adminUserRoleForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// an admin who took away their own role couldn't get it back, and might leave nobody who can
+	if id == app.authenticatedUserID(r) {
+		app.sessionManager.Put(r.Context(), "flash", "you can't change your own role")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	err = app.users.SetRole(id, form.Role)
+	if err != nil {
+		switch {
+		case errors.Is(err, models.ErrInvalidRole):
+			app.clientError(w, http.StatusBadRequest)
+		case errors.Is(err, models.ErrNoRecord):
+			app.notFound(w)
+		default:
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("the user's role is now %s", form.Role))
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// handler for the list of every snippet, expired or not, which can be searched by title or author
+func (app *application) adminSnippets(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	page, err := pageNumber(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	snippets, more, err := app.admin.Snippets(query, page)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Query = query
+	data.Snippets = snippets
+	data.Pagination = newPagePagination("/admin/snippets", url.Values{"q": {query}}, page, more)
+	app.render(w, r, http.StatusOK, "admin_snippets.tmpl.html", data)
+}
+
+// handler to make a snippet expire straight away, taking it off the site
+func (app *application) adminSnippetExpirePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.admin.ExpireSnippet(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "the snippet has expired")
+	http.Redirect(w, r, "/admin/snippets", http.StatusSeeOther)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/internal/models/mocks"
+)
+
+func TestAdmin(t *testing.T) {
+	app := newTestApplication(t)
+
+	// one client for the admin, and another for the user they disable
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+	other := newTestServer(t, app.routes())
+	defer other.Close()
+
+	// the admin pages are only for admins
+	ts.login(t)
+	code, _, body := ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusForbidden)
+	_, _, body = ts.get(t, "/")
+	assert.Equal(t, strings.Contains(body, `href="/admin"`), false)
+
+	err := app.users.SetRole(mocks.MockUser.ID, models.RoleAdmin)
+	assert.NilError(t, err)
+
+	code, _, body = ts.get(t, "/admin")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, `href="/admin"`), true)
+	assert.Equal(t, strings.Contains(body, "3 (0 disabled)"), true)
+	assert.Equal(t, strings.Count(body, "<meter"), 2*adminDashboardDays)
+
+	code, _, body = ts.get(t, "/admin/users?q=DUPE")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, mocks.DupeEmail), true)
+	assert.Equal(t, strings.Contains(body, mocks.MockUser.Email), false)
+	code, _, _ = ts.get(t, "/admin/users?page=0")
+	assert.Equal(t, code, http.StatusBadRequest)
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	// admins can't disable themselves
+	code, headers, _ := ts.postForm(t, "/admin/users/disable/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/admin/users")
+	_, _, body = ts.get(t, "/admin/users")
+	assert.Equal(t, strings.Contains(body, "you can&#39;t disable your own account"), true)
+
+	code, _, _ = ts.postForm(t, "/admin/users/disable/99", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// admins can change anybody else's role, but not their own
+	form.Set("role", models.RoleModerator)
+	code, headers, _ = ts.postForm(t, "/admin/users/role/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/admin/users")
+	user, err := app.users.Get(2)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Role, models.RoleModerator)
+	_, _, body = ts.get(t, "/admin/users")
+	assert.Equal(t, strings.Contains(body, "the user&#39;s role is now moderator"), true)
+	assert.Equal(t, strings.Contains(body, `<option value="moderator" selected>`), true)
+
+	form.Set("role", models.RoleUser)
+	code, _, _ = ts.postForm(t, "/admin/users/role/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	user, err = app.users.Get(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, user.Role, models.RoleAdmin)
+	_, _, body = ts.get(t, "/admin/users")
+	assert.Equal(t, strings.Contains(body, "you can&#39;t change your own role"), true)
+
+	code, _, _ = ts.postForm(t, "/admin/users/role/99", form)
+	assert.Equal(t, code, http.StatusNotFound)
+	form.Set("role", "superuser")
+	code, _, _ = ts.postForm(t, "/admin/users/role/2", form)
+	assert.Equal(t, code, http.StatusBadRequest)
+	form.Del("role")
+
+	// disabling a user logs them out, and stops them logging in again
+	other.loginAs(t, mocks.DupeEmail, mocks.MockPassword)
+	code, _, _ = ts.postForm(t, "/admin/users/disable/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = other.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	_, _, body = other.get(t, "/user/login")
+	loginForm := url.Values{}
+	loginForm.Add("email", mocks.DupeEmail)
+	loginForm.Add("password", mocks.MockPassword)
+	loginForm.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, body = other.postForm(t, "/user/login", loginForm)
+	assert.Equal(t, code, http.StatusForbidden)
+	assert.Equal(t, strings.Contains(body, "your account has been disabled"), true)
+
+	code, _, _ = ts.postForm(t, "/admin/users/enable/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	other.loginAs(t, mocks.DupeEmail, mocks.MockPassword)
+
+	// but their API tokens were revoked when they were disabled, and don't come back
+	code, _, _ = other.request(t, http.MethodGet, "/api/v1/snippets", "", mocks.MockOtherWriteToken)
+	assert.Equal(t, code, http.StatusUnauthorized)
+	tokens, err := app.tokens.ForUser(2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(tokens), 0)
+
+	// whether a snippet has expired is judged by the app's clock
+	app.now = func() time.Time { return mocks.MockSnippet.Expires.Add(time.Minute) }
+	_, _, body = ts.get(t, "/admin/snippets?q=silent")
+	assert.Equal(t, strings.Contains(body, "/admin/snippets/expire/1"), false)
+	app.now = time.Now
+	_, _, body = ts.get(t, "/admin/snippets?q=silent")
+	assert.Equal(t, strings.Contains(body, "/admin/snippets/expire/1"), true)
+
+	// expiring a snippet takes it off the site, but it is still listed for admins
+	code, _, _ = ts.postForm(t, "/admin/snippets/expire/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, body = ts.get(t, "/admin/snippets?q=silent")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, mocks.MockSnippet.Title), true)
+	assert.Equal(t, strings.Contains(body, "/admin/snippets/expire/1"), false)
+
+	code, _, _ = ts.postForm(t, "/admin/snippets/expire/99", form)
+	assert.Equal(t, code, http.StatusNotFound)
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/julienschmidt/httprouter"
+	"snippetbox.lets-go/internal/models"
+)
+
+// handlers for the JSON API under /api/v1. they do the same jobs as the HTML handlers, but
+// read JSON request bodies and send JSON responses. see apiRoutes() for the URLs
+
+// the metadata sent alongside a page of snippets. the links are empty when there is no
+// page in that direction
+type apiPageMetadata struct {
+	Prev string `json:"prev,omitempty"`
+	Next string `json:"next,omitempty"`
+}
+
+// handler for listing the latest snippets, a page at a time. like the home page, it takes
+// ?after= or ?before= to move between pages, and also ?size= to change the page size
+func (app *application) apiSnippetList(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	cursor := models.Cursor{Size: app.pageSize}
+	var err error
+	if after := query.Get("after"); after != "" {
+		cursor.After, err = strconv.Atoi(after)
+		if err != nil || cursor.After < 1 {
+			app.apiError(w, r, http.StatusBadRequest, "after must be a positive integer")
+			return
+		}
+	}
+	if before := query.Get("before"); before != "" {
+		cursor.Before, err = strconv.Atoi(before)
+		if err != nil || cursor.Before < 1 {
+			app.apiError(w, r, http.StatusBadRequest, "before must be a positive integer")
+			return
+		}
+		if cursor.After > 0 {
+			app.apiError(w, r, http.StatusBadRequest, "after and before cannot be used together")
+			return
+		}
+	}
+	if size := query.Get("size"); size != "" {
+		cursor.Size, err = strconv.Atoi(size)
+		if err != nil || cursor.Size < 1 || cursor.Size > models.MaxPageSize {
+			app.apiError(w, r, http.StatusBadRequest, fmt.Sprintf("size must be between 1 and %d", models.MaxPageSize))
+			return
+		}
+	}
+
+	snippets, page, err := app.snippets.Latest(cursor)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// reuse the links built for the home page. the size has to be carried between pages
+	links := newCursorPagination("/api/v1/snippets", page)
+	metadata := apiPageMetadata{Prev: links.PrevURL, Next: links.NextURL}
+	if query.Has("size") {
+		if metadata.Prev != "" {
+			metadata.Prev += "&size=" + strconv.Itoa(cursor.Size)
+		}
+		if metadata.Next != "" {
+			metadata.Next += "&size=" + strconv.Itoa(cursor.Size)
+		}
+	}
+
+	// always send a JSON array, even when there are no snippets
+	if snippets == nil {
+		snippets = []*models.Snippet{}
+	}
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippets": snippets, "metadata": metadata}, nil)
+}
+
+// handler for fetching a single snippet
+func (app *application) apiSnippetGet(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": snippet}, nil)
+}
+
+// handler for creating a snippet. the body is a JSON object with the same fields as the
+// HTML create form: {"title": "...", "content": "...", "expires": 7}
+func (app *application) apiSnippetCreate(w http.ResponseWriter, r *http.Request) {
+	var form snippetCreateForm
+	if err := app.readJSON(w, r, &form); err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form.validate(false)
+	if !form.Valid() {
+		app.apiFailedValidation(w, r, form.Validator)
+		return
+	}
+
+	id, err := app.snippets.Insert(app.authenticatedUserID(r), form.Title, form.Content, form.Expires)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	// read the new snippet back so that the response holds its author and timestamps
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", fmt.Sprintf("/api/v1/snippets/%d", id))
+	app.writeJSON(w, r, http.StatusCreated, envelope{"snippet": snippet}, headers)
+}
+
+// handler for replacing the contents of a snippet. the body is the same as for create, except
+// that expires can be left out (or set to 0) to keep the current expiry
+func (app *application) apiSnippetUpdate(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	var form snippetCreateForm
+	if err := app.readJSON(w, r, &form); err != nil {
+		app.apiError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	form.validate(true)
+	if !form.Valid() {
+		app.apiFailedValidation(w, r, form.Validator)
+		return
+	}
+
+	err := app.snippets.Update(snippet.ID, app.authenticatedUserID(r), form.Title, form.Content, form.Expires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	snippet, err = app.snippets.Get(snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	app.writeJSON(w, r, http.StatusOK, envelope{"snippet": snippet}, nil)
+}
+
+// handler for deleting a snippet. a successful delete has no response body
+func (app *application) apiSnippetDelete(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// the JSON API version of ownedSnippet()
+func (app *application) apiOwnedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.apiNotFound(w, r)
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.apiNotFound(w, r)
+		} else {
+			app.apiServerError(w, r, err)
+		}
+		return nil, false
+	}
+
+	if snippet.UserID != app.authenticatedUserID(r) {
+		app.apiError(w, r, http.StatusForbidden, "you do not have permission to change this snippet")
+		return nil, false
+	}
+	return snippet, true
+}
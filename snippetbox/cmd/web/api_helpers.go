@@ -0,0 +1,143 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"snippetbox.lets-go/internal/validator"
+)
+
+// the prefix shared by every JSON API route
+const apiPrefix = "/api/"
+
+// largest request body the JSON API will read, in bytes
+const maxJSONBodyBytes = 1_048_576
+
+// every JSON response is an object, so the data is always wrapped in an envelope with
+// a descriptive key, like {"snippet": {...}} or {"error": {...}}
+type envelope map[string]any
+
+// the body of every JSON API error response, under the "error" key of the envelope.
+// Fields holds the validation error for each invalid field of a request body
+type apiErrorBody struct {
+	Status  int               `json:"status"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+// report whether the request is for the JSON API, so that shared handlers like
+// NotFound and recoverPanic can answer in JSON rather than plain text
+func isAPIRequest(r *http.Request) bool {
+	return strings.HasPrefix(r.URL.Path, apiPrefix)
+}
+
+// encode data as JSON and send it with the given status code. any headers are added to
+// the response first
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) {
+	js, err := json.MarshalIndent(data, "", "\t")
+	if err != nil {
+		app.apiServerError(w, r, err)
+		return
+	}
+	js = append(js, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(js)
+}
+
+// decode the JSON request body into dst. the body must hold exactly one JSON value with no
+// unknown fields, and be no larger than maxJSONBodyBytes. the errors returned are safe to
+// show to the client
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxJSONBodyBytes)
+
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(dst)
+	if err != nil {
+		var syntaxError *json.SyntaxError
+		var unmarshalTypeError *json.UnmarshalTypeError
+		var invalidUnmarshalError *json.InvalidUnmarshalError
+
+		switch {
+		case errors.As(err, &syntaxError):
+			return fmt.Errorf("body contains badly-formed JSON (at character %d)", syntaxError.Offset)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			return errors.New("body contains badly-formed JSON")
+		case errors.As(err, &unmarshalTypeError):
+			if unmarshalTypeError.Field != "" {
+				return fmt.Errorf("body contains incorrect JSON type for field %q", unmarshalTypeError.Field)
+			}
+			return fmt.Errorf("body contains incorrect JSON type (at character %d)", unmarshalTypeError.Offset)
+		case errors.Is(err, io.EOF):
+			return errors.New("body must not be empty")
+		case strings.HasPrefix(err.Error(), "json: unknown field "):
+			return fmt.Errorf("body contains unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+		case err.Error() == "http: request body too large":
+			return fmt.Errorf("body must not be larger than %d bytes", maxJSONBodyBytes)
+
+		// like decodePostForm(), a bad destination is a bug in our code so we panic
+		case errors.As(err, &invalidUnmarshalError):
+			panic(err)
+		default:
+			return err
+		}
+	}
+
+	// a second Decode() should hit the end of the body. anything else means there was more
+	// than one JSON value
+	if err := dec.Decode(&struct{}{}); err != io.EOF {
+		return errors.New("body must only contain a single JSON value")
+	}
+	return nil
+}
+
+// send a JSON error response with the given status code and message
+func (app *application) apiError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	app.writeJSON(w, r, status, envelope{"error": apiErrorBody{Status: status, Message: message}}, nil)
+}
+
+// the JSON API version of serverError(). the details are logged, and the client gets a generic message
+func (app *application) apiServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logServerError(r, err)
+
+	app.apiError(w, r, http.StatusInternalServerError, "the server encountered a problem and could not process your request")
+}
+
+// the JSON API version of clientError(), using the standard status text as the message
+func (app *application) apiClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.apiError(w, r, status, strings.ToLower(http.StatusText(status)))
+}
+
+// the JSON API version of notFound()
+func (app *application) apiNotFound(w http.ResponseWriter, r *http.Request) {
+	app.apiClientError(w, r, http.StatusNotFound)
+}
+
+// send a 422 response listing the validation errors in v
+func (app *application) apiFailedValidation(w http.ResponseWriter, r *http.Request, v validator.Validator) {
+	body := apiErrorBody{
+		Status:  http.StatusUnprocessableEntity,
+		Message: "the request failed validation",
+		Fields:  v.FieldErrors,
+	}
+	if len(v.NonFieldErrors) > 0 {
+		body.Message = strings.Join(v.NonFieldErrors, "; ")
+	}
+	app.writeJSON(w, r, http.StatusUnprocessableEntity, envelope{"error": body}, nil)
+}
+
+// send a 401 response for a request with a bad or expired API token
+func (app *application) apiInvalidToken(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	app.apiError(w, r, http.StatusUnauthorized, "invalid or expired authentication token")
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/internal/models/mocks"
+)
+
+// decode a JSON error envelope from an API response body
+func decodeAPIError(t *testing.T, body string) apiErrorBody {
+	t.Helper()
+	var resp struct {
+		Error apiErrorBody `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("response is not a JSON error envelope: %s: %q", err, body)
+	}
+	return resp.Error
+}
+
+func TestAPISnippetGet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Valid ID", func(t *testing.T) {
+		code, headers, body := ts.request(t, http.MethodGet, "/api/v1/snippets/1", "", "")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, headers.Get("Content-Type"), "application/json")
+
+		var resp struct {
+			Snippet models.Snippet `json:"snippet"`
+		}
+		if err := json.Unmarshal([]byte(body), &resp); err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, resp.Snippet.ID, mocks.MockSnippet.ID)
+		assert.Equal(t, resp.Snippet.Title, mocks.MockSnippet.Title)
+		assert.Equal(t, resp.Snippet.UserName, mocks.MockUser.Name)
+	})
+
+	for _, urlPath := range []string{"/api/v1/snippets/2", "/api/v1/snippets/-1", "/api/v1/snippets/foo", "/api/v1/nothing"} {
+		t.Run(urlPath, func(t *testing.T) {
+			code, _, body := ts.request(t, http.MethodGet, urlPath, "", "")
+			assert.Equal(t, code, http.StatusNotFound)
+			assert.Equal(t, decodeAPIError(t, body).Status, http.StatusNotFound)
+		})
+	}
+
+	t.Run("Method not allowed", func(t *testing.T) {
+		code, headers, body := ts.request(t, http.MethodPatch, "/api/v1/snippets/1", "", "")
+		assert.Equal(t, code, http.StatusMethodNotAllowed)
+		assert.Equal(t, strings.Contains(headers.Get("Allow"), http.MethodPut), true)
+		assert.Equal(t, decodeAPIError(t, body).Status, http.StatusMethodNotAllowed)
+	})
+}
+
+func TestAPISnippetList(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, body := ts.request(t, http.MethodGet, "/api/v1/snippets", "", "")
+	assert.Equal(t, code, http.StatusOK)
+
+	var resp struct {
+		Snippets []models.Snippet `json:"snippets"`
+		Metadata apiPageMetadata  `json:"metadata"`
+	}
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(resp.Snippets), 1)
+	assert.Equal(t, resp.Metadata.Next, "")
+
+	for _, query := range []string{"?after=x", "?after=1&before=2", "?size=0", "?size=1000"} {
+		t.Run(query, func(t *testing.T) {
+			code, _, body := ts.request(t, http.MethodGet, "/api/v1/snippets"+query, "", "")
+			assert.Equal(t, code, http.StatusBadRequest)
+			assert.Equal(t, decodeAPIError(t, body).Status, http.StatusBadRequest)
+		})
+	}
+}
+
+func TestAPISnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const validBody = `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7}`
+
+	tests := []struct {
+		name       string
+		body       string
+		token      string
+		wantCode   int
+		wantField  string
+		wantErrMsg string
+	}{
+		{
+			name:     "Valid submission",
+			body:     validBody,
+			token:    mocks.MockWriteToken,
+			wantCode: http.StatusCreated,
+		},
+		{
+			name:     "Unauthenticated",
+			body:     validBody,
+			wantCode: http.StatusUnauthorized,
+		},
+		{
+			name:       "Invalid token",
+			body:       validBody,
+			token:      "sbx_nosuchtoken",
+			wantCode:   http.StatusUnauthorized,
+			wantErrMsg: "invalid or expired authentication token",
+		},
+		{
+			name:       "Read-only token",
+			body:       validBody,
+			token:      mocks.MockReadToken,
+			wantCode:   http.StatusForbidden,
+			wantErrMsg: "your token needs the write scope to access this resource",
+		},
+		{
+			name:      "Empty title",
+			body:      `{"title": "", "content": "Climb Mount Fuji", "expires": 7}`,
+			token:     mocks.MockWriteToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantField: "title",
+		},
+		{
+			name:      "Invalid expiry",
+			body:      `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 2}`,
+			token:     mocks.MockWriteToken,
+			wantCode:  http.StatusUnprocessableEntity,
+			wantField: "expires",
+		},
+		{
+			name:       "Unknown field",
+			body:       `{"title": "O snail", "content": "Climb Mount Fuji", "expires": 7, "author": 2}`,
+			token:      mocks.MockWriteToken,
+			wantCode:   http.StatusBadRequest,
+			wantErrMsg: `body contains unknown field "author"`,
+		},
+		{
+			name:       "Badly-formed JSON",
+			body:       `{"title": "O snail",`,
+			token:      mocks.MockWriteToken,
+			wantCode:   http.StatusBadRequest,
+			wantErrMsg: "body contains badly-formed JSON",
+		},
+		{
+			name:       "Two values",
+			body:       validBody + validBody,
+			token:      mocks.MockWriteToken,
+			wantCode:   http.StatusBadRequest,
+			wantErrMsg: "body must only contain a single JSON value",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.request(t, http.MethodPost, "/api/v1/snippets", tt.body, tt.token)
+			assert.Equal(t, code, tt.wantCode)
+
+			if code == http.StatusCreated {
+				assert.Equal(t, headers.Get("Location"), "/api/v1/snippets/2")
+				assert.Equal(t, strings.Contains(body, `"title": "O snail"`), true)
+				return
+			}
+
+			apiErr := decodeAPIError(t, body)
+			assert.Equal(t, apiErr.Status, tt.wantCode)
+			if tt.wantField != "" {
+				assert.Equal(t, apiErr.Fields[tt.wantField] != "", true)
+			}
+			if tt.wantErrMsg != "" {
+				assert.Equal(t, apiErr.Message, tt.wantErrMsg)
+			}
+		})
+	}
+}
+
+func TestAPISnippetUpdateAndDelete(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	const body = `{"title": "An old silent pond", "content": "A frog jumps into the pond", "expires": 365}`
+
+	// the snippet belongs to MockUser, so the other fixture user can't touch it
+	code, _, _ := ts.request(t, http.MethodPut, "/api/v1/snippets/1", body, mocks.MockOtherWriteToken)
+	assert.Equal(t, code, http.StatusForbidden)
+	code, _, _ = ts.request(t, http.MethodDelete, "/api/v1/snippets/1", "", mocks.MockOtherWriteToken)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, _, respBody := ts.request(t, http.MethodPut, "/api/v1/snippets/1", body, mocks.MockWriteToken)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(respBody, `"title": "An old silent pond"`), true)
+
+	code, _, respBody = ts.request(t, http.MethodDelete, "/api/v1/snippets/1", "", mocks.MockWriteToken)
+	assert.Equal(t, code, http.StatusNoContent)
+	assert.Equal(t, respBody, "")
+
+	code, _, _ = ts.request(t, http.MethodGet, "/api/v1/snippets/1", "", "")
+	assert.Equal(t, code, http.StatusNotFound)
+}
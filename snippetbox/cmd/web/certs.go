@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"log/slog"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// the certReloader holds the TLS certificate from a cert and key file, and swaps in a new one
+// when the files change or the process receives SIGHUP, so that certificates can be rotated
+// without a restart. the server gets the certificate through GetCertificate for every new
+// connection, so connections which are already open carry on with the old one.
+//
+// a new pair which doesn't load, like a certificate and key which don't match, is logged and
+// ignored, and the last good certificate stays in use.
+type certReloader struct {
+	certFile string
+	keyFile  string
+	logger   *slog.Logger
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+
+	// the modification times of the files when we last loaded them, good pair or not
+	certMod time.Time
+	keyMod  time.Time
+}
+
+// create a certReloader and load the certificate from certFile and keyFile. unlike a reload,
+// a pair which doesn't load is an error here, since there is no certificate to fall back to
+func newCertReloader(certFile, keyFile string, logger *slog.Logger) (*certReloader, error) {
+	cr := &certReloader{certFile: certFile, keyFile: keyFile, logger: logger}
+	cr.certMod, cr.keyMod = cr.modTimes()
+
+	cert, err := loadCertificate(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	cr.cert = cert
+	return cr, nil
+}
+
+// load a certificate and key pair, with its leaf certificate parsed so that we can log it
+func loadCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	if cert.Leaf == nil {
+		cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &cert, nil
+}
+
+// return the current certificate. it has the signature of tls.Config.GetCertificate
+func (cr *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	cr.mu.RLock()
+	defer cr.mu.RUnlock()
+	return cr.cert, nil
+}
+
+// load the certificate from the files again. if that fails the error is logged and returned,
+// and the current certificate is kept
+func (cr *certReloader) reload() error {
+	cert, err := loadCertificate(cr.certFile, cr.keyFile)
+	if err != nil {
+		cr.logger.Error("rejected new TLS certificate, keeping the current one", "cert", cr.certFile, "key", cr.keyFile, "error", err)
+		return err
+	}
+
+	cr.mu.Lock()
+	cr.cert = cert
+	cr.mu.Unlock()
+
+	cr.logger.Info("loaded new TLS certificate",
+		"subject", cert.Leaf.Subject.String(),
+		"not_after", cert.Leaf.NotAfter,
+	)
+	return nil
+}
+
+// return the modification times of the cert and key files. a file which can't be read, maybe
+// because it is being replaced, has a zero time
+func (cr *certReloader) modTimes() (time.Time, time.Time) {
+	var certMod, keyMod time.Time
+	if fi, err := os.Stat(cr.certFile); err == nil {
+		certMod = fi.ModTime()
+	}
+	if fi, err := os.Stat(cr.keyFile); err == nil {
+		keyMod = fi.ModTime()
+	}
+	return certMod, keyMod
+}
+
+// reload the certificate if either file has changed since we last looked. the times are noted
+// even if the reload fails, so that a bad pair is only tried once. writing the other file of
+// the pair changes its time again, and then we try once more
+func (cr *certReloader) reloadIfChanged() {
+	certMod, keyMod := cr.modTimes()
+	if certMod.Equal(cr.certMod) && keyMod.Equal(cr.keyMod) {
+		return
+	}
+	cr.certMod, cr.keyMod = certMod, keyMod
+	cr.reload()
+}
+
+// check the files for changes every interval, and reload whenever the process receives
+// SIGHUP, until ctx is cancelled. an interval of zero turns off the checks, leaving only
+// SIGHUP. this is meant to be started with app.background()
+func (cr *certReloader) Watch(ctx context.Context, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	// a nil channel never fires, so with no interval we only wait on the signal
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	for {
+		select {
+		case <-tick:
+			cr.reloadIfChanged()
+		case <-hup:
+			cr.logger.Info("reloading TLS certificate", "signal", "SIGHUP")
+			cr.certMod, cr.keyMod = cr.modTimes()
+			cr.reload()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
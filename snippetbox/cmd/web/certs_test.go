@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+// make a self-signed certificate for name and return it and its key, PEM encoded
+func makeCertificate(t *testing.T, name string) ([]byte, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// write b to path, with a modification time of mod so that the change is seen even on file
+// systems with coarse timestamps
+func writeFileAt(t *testing.T, path string, b []byte, mod time.Time) {
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, mod, mod); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// create a certReloader for a certificate for name, in a temporary directory. it logs into
+// the returned buffer
+func newTestCertReloader(t *testing.T, name string) (*certReloader, *bytes.Buffer) {
+	dir := t.TempDir()
+	certPEM, keyPEM := makeCertificate(t, name)
+	writeFileAt(t, filepath.Join(dir, "cert.pem"), certPEM, time.Now().Add(-time.Hour))
+	writeFileAt(t, filepath.Join(dir, "key.pem"), keyPEM, time.Now().Add(-time.Hour))
+
+	var logs bytes.Buffer
+	cr, err := newCertReloader(filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem"), newLogger(&logs, "text", "info"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cr, &logs
+}
+
+// the common name of the certificate the reloader is currently serving
+func servedName(t *testing.T, cr *certReloader) string {
+	cert, err := cr.GetCertificate(nil)
+	assert.NilError(t, err)
+	return cert.Leaf.Subject.CommonName
+}
+
+func TestNewCertReloader(t *testing.T) {
+	cr, _ := newTestCertReloader(t, "one.example.com")
+	assert.Equal(t, servedName(t, cr), "one.example.com")
+
+	// with no good pair to start from, it's an error
+	certPEM, _ := makeCertificate(t, "two.example.com")
+	_, keyPEM := makeCertificate(t, "two.example.com")
+	writeFileAt(t, cr.certFile, certPEM, time.Now())
+	writeFileAt(t, cr.keyFile, keyPEM, time.Now())
+
+	_, err := newCertReloader(cr.certFile, cr.keyFile, cr.logger)
+	assert.Equal(t, err != nil, true)
+}
+
+func TestCertReloaderReloadIfChanged(t *testing.T) {
+	cr, logs := newTestCertReloader(t, "one.example.com")
+
+	// nothing has changed, so nothing happens
+	cr.reloadIfChanged()
+	assert.Equal(t, logs.Len(), 0)
+
+	// a new certificate is written before its key. the certificate doesn't match the old key,
+	// so it is rejected and we carry on with the old one
+	certPEM, keyPEM := makeCertificate(t, "two.example.com")
+	writeFileAt(t, cr.certFile, certPEM, time.Now())
+	cr.reloadIfChanged()
+
+	assert.Equal(t, servedName(t, cr), "one.example.com")
+	assert.Equal(t, strings.Contains(logs.String(), `msg="rejected new TLS certificate, keeping the current one"`), true)
+
+	// the rejected pair isn't tried again until something changes
+	logs.Reset()
+	cr.reloadIfChanged()
+	assert.Equal(t, logs.Len(), 0)
+
+	// then the key arrives, and the new pair is used
+	writeFileAt(t, cr.keyFile, keyPEM, time.Now())
+	cr.reloadIfChanged()
+
+	assert.Equal(t, servedName(t, cr), "two.example.com")
+	assert.Equal(t, strings.Contains(logs.String(), `msg="loaded new TLS certificate" subject="CN=two.example.com"`), true)
+
+	// a file which has gone missing is rejected too
+	os.Remove(cr.keyFile)
+	cr.reloadIfChanged()
+	assert.Equal(t, servedName(t, cr), "two.example.com")
+}
+
+func TestCertReloaderWatch(t *testing.T) {
+	cr, _ := newTestCertReloader(t, "one.example.com")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		cr.Watch(ctx, 10*time.Millisecond)
+		close(done)
+	}()
+
+	certPEM, keyPEM := makeCertificate(t, "two.example.com")
+	writeFileAt(t, cr.keyFile, keyPEM, time.Now())
+	writeFileAt(t, cr.certFile, certPEM, time.Now())
+
+	// the new certificate is picked up without anyone asking
+	deadline := time.Now().Add(5 * time.Second)
+	for servedName(t, cr) != "two.example.com" {
+		if time.Now().After(deadline) {
+			t.Fatal("new certificate was not loaded")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Watch did not stop")
+	}
+}
@@ -2,4 +2,18 @@ package main
 
 type contextKey string
 
-const isAuthenticatedContextKey = contextKey("isAuthenticated")
+// the *models.User who made the request. it is set by whichever middleware authenticated
+// the request, from the session or from an API token, and is missing for anonymous requests
+const authenticatedUserContextKey = contextKey("authenticatedUser")
+
+// the *models.Token which authenticated a JSON API request
+const apiTokenContextKey = contextKey("apiToken")
+
+// the id given to the request by the requestID middleware, for the logs
+const requestIDContextKey = contextKey("requestID")
+
+// the *routeLabel which the router fills in for the instrument middleware
+const routeLabelContextKey = contextKey("routeLabel")
+
+// the id of the models.UserSession for a request from a logged in browser session
+const userSessionIDContextKey = contextKey("userSessionID")
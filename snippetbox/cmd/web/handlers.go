@@ -4,28 +4,80 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/skip2/go-qrcode"
 	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/internal/totp"
 	"snippetbox.lets-go/internal/validator"
 )
 
 // define a home handler function which writes a byte slice containing
 func (app *application) home(w http.ResponseWriter, r *http.Request) {
 
+	// read the ?after= and ?before= query string values which say which page of snippets to show.
+	// only one of them can be used at a time
+	cursor := models.Cursor{Size: app.pageSize}
+	var err error
+	if after := r.URL.Query().Get("after"); after != "" {
+		cursor.After, err = strconv.Atoi(after)
+		if err != nil || cursor.After < 1 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+	if before := r.URL.Query().Get("before"); before != "" {
+		cursor.Before, err = strconv.Atoi(before)
+		if err != nil || cursor.Before < 1 || cursor.After > 0 {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+	}
+
 	// because httprouter matches "/" exactly, we can remove any manual checks of r.URL.Path != "/"
-	snippets, err := app.snippets.Latest()
+	snippets, page, err := app.snippets.Latest(cursor)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
 	// create new templateData struct containing our default data
 	data := app.newTemplateData(r)
 	data.Snippets = snippets
+	data.Pagination = newCursorPagination("/", page)
+
+	app.render(w, r, http.StatusOK, "home.tmpl.html", data)
+}
+
+// handler for searching snippet titles and content
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	query := strings.TrimSpace(r.URL.Query().Get("q"))
+
+	page, err := pageNumber(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Query = query
+
+	// an empty query just shows the search form
+	if query != "" {
+		snippets, more, err := app.snippets.Search(query, page)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data.Snippets = snippets
+		data.Pagination = newPagePagination("/search", url.Values{"q": {query}}, page, more)
+	}
 
-	app.render(w, http.StatusOK, "home.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "search.tmpl.html", data)
 }
 
 // handler for viewing a snippet
@@ -46,7 +98,7 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 		if errors.Is(err, models.ErrNoRecord) {
 			app.notFound(w)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
@@ -55,7 +107,29 @@ func (app *application) snippetView(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Snippet = snippet
 
-	app.render(w, http.StatusOK, "view.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "view.tmpl.html", data)
+}
+
+// handler for listing every snippet published by a single user
+func (app *application) snippetUser(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	snippets, err := app.snippets.ByUser(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippets = snippets
+
+	app.render(w, r, http.StatusOK, "user.tmpl.html", data)
 }
 
 func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
@@ -66,15 +140,35 @@ func (app *application) snippetCreate(w http.ResponseWriter, r *http.Request) {
 	data.Form = snippetCreateForm{
 		Expires: 365,
 	}
-	app.render(w, http.StatusOK, "create.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "create.tmpl.html", data)
 }
 
 // struct to represent form data and validation errors for all form fields.
+// the JSON API decodes its request bodies into the same struct, so it has json tags too
 type snippetCreateForm struct {
-	Title               string     `form:"title"`
-	Content             string     `form:"content"`
-	Expires             int        `form:"expires"`
-	validator.Validator `form:"-"` // anonymous embedding
+	Title               string              `form:"title" json:"title"`
+	Content             string              `form:"content" json:"content"`
+	Expires             int                 `form:"expires" json:"expires"`
+	validator.Validator `form:"-" json:"-"` // anonymous embedding
+}
+
+// validate the form contents. this is shared by the create and edit handlers so that
+// both apply exactly the same rules to a snippet. the only difference is that an edit
+// can keep the snippet's current expiry, with models.KeepExpiry
+func (form *snippetCreateForm) validate(editing bool) {
+	// because the Validator type is embedded in our snippetCreateForm struct,
+	// we can call CheckField() directly on it to execute our validation checks.
+	// CheckField() will add the provided key and error message to the FieldErrors map if the check does not evaluate to true.
+	// for example, in the first line here we "check that the form.Title field is not blank". In the second, we "check that the form.Title field has a max char length of 100"
+	// and so on.
+	form.CheckField(validator.NotBlank(form.Title), "title", "this field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Title, 100), "title", "this field cannot be more than 100 characters long")
+	form.CheckField(validator.NotBlank(form.Content), "content", "this field cannot be blank")
+	if editing {
+		form.CheckField(validator.PermittedValue(form.Expires, models.KeepExpiry, 1, 7, 365), "expires", "this field must equal 0, 1, 7, or 365")
+	} else {
+		form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "this field must equal 1, 7, or 365")
+	}
 }
 
 // handler for creating snippets
@@ -87,27 +181,22 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	// because the Validator type is embedded in our snippetCreateForm struct,
-	// we can call CheckField() directly on it to execute our validation checks.
-	// CheckField() will add the provided key and error message to the FieldErrors map if the check does not evaluate to true.
-	// for example, in the first line here we "check that the form.Title field is not blank". In the second, we "check that the form.Title field has a max char length of 100"
-	// and so on.
-	form.CheckField(validator.NotBlank(form.Title), "title", "this field cannot be blank")
-	form.CheckField(validator.MaxChars(form.Title, 100), "title", "this field cannot be more than 100 characters long")
-	form.CheckField(validator.NotBlank(form.Content), "content", "this field cannot be blank")
-	form.CheckField(validator.PermittedValue(form.Expires, 1, 7, 365), "expires", "this field must equal 1, 7, or 365")
-
+	form.validate(false)
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "create.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "create.tmpl.html", data)
 		return
 	}
 
+	// this route sits behind requireAuthentication, so the session will always hold the id of the
+	// user creating the snippet. we record them as the author of the new snippet
+	userID := app.authenticatedUserID(r)
+
 	// pass the data to SnippetModel.Insert(), receiving the ID of the new record back
-	id, err := app.snippets.Insert(form.Title, form.Content, form.Expires)
+	id, err := app.snippets.Insert(userID, form.Title, form.Content, form.Expires)
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -118,6 +207,85 @@ func (app *application) snippetCreatePost(w http.ResponseWriter, r *http.Request
 	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", id), http.StatusSeeOther)
 }
 
+// handler to display the edit form for a snippet, pre-populated with its current contents.
+// the expiry starts on "keep", so that fixing a typo doesn't change when the snippet goes
+func (app *application) snippetEdit(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r, "")
+	if !ok {
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Snippet = snippet
+	data.Form = snippetCreateForm{
+		Title:   snippet.Title,
+		Content: snippet.Content,
+		Expires: models.KeepExpiry,
+	}
+	app.render(w, r, http.StatusOK, "edit.tmpl.html", data)
+}
+
+// handler for saving changes to a snippet
+func (app *application) snippetEditPost(w http.ResponseWriter, r *http.Request) {
+	snippet, ok := app.ownedSnippet(w, r, "")
+	if !ok {
+		return
+	}
+
+	var form snippetCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.validate(true)
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Snippet = snippet
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "edit.tmpl.html", data)
+		return
+	}
+
+	// Update() checks the author again, in case the snippet was deleted or expired since we
+	// read it
+	err = app.snippets.Update(snippet.ID, app.authenticatedUserID(r), form.Title, form.Content, form.Expires)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "snippet successfully updated!")
+	http.Redirect(w, r, fmt.Sprintf("/snippet/view/%d", snippet.ID), http.StatusSeeOther)
+}
+
+// handler for deleting a snippet before it expires
+func (app *application) snippetDeletePost(w http.ResponseWriter, r *http.Request) {
+	// moderators can take down anybody's snippet
+	snippet, ok := app.ownedSnippet(w, r, models.RoleModerator)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(snippet.ID)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "snippet successfully deleted!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
 type userSignupForm struct {
 	Name                string `form:"name"`
 	Email               string `form:"email"`
@@ -129,7 +297,7 @@ type userSignupForm struct {
 func (app *application) userSignup(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userSignupForm{}
-	app.render(w, http.StatusOK, "signup.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "signup.tmpl.html", data)
 }
 
 func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
@@ -153,28 +321,39 @@ func (app *application) userSignupPost(w http.ResponseWriter, r *http.Request) {
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		return
 	}
 
 	// try to create a new user record in the database. if the email already exists
 	// then add an error message to the form and re-display it.
-	err = app.users.Insert(form.Name, form.Email, form.Password)
+	id, err := app.users.Insert(form.Name, form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrDuplicateEmail) {
 			form.AddFieldError("email", "email address is already in use")
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "signup.tmpl.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	// the new account can't create snippets until the user has followed the link in the
+	// activation email. recording the email also starts the clock for resending it
+	sent, err := app.users.RecordActivationSent(id, 0)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if sent {
+		app.sendActivationEmail(id, form.Name, form.Email)
+	}
+
 	// otherwise add a confirmation flash message to the session confirming their signup worked.
-	app.sessionManager.Put(r.Context(), "flash", "your signup was successful. please log in")
+	app.sessionManager.Put(r.Context(), "flash", "your signup was successful. we've emailed you a link to activate your account. please log in")
 
 	// redirect user to the login page
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
@@ -190,7 +369,7 @@ type userLoginForm struct {
 func (app *application) userLogin(w http.ResponseWriter, r *http.Request) {
 	data := app.newTemplateData(r)
 	data.Form = userLoginForm{}
-	app.render(w, http.StatusOK, "login.tmpl.html", data)
+	app.render(w, r, http.StatusOK, "login.tmpl.html", data)
 }
 
 func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
@@ -206,17 +385,36 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 	// and also check the format of the email address as a UX-nicety (in case the user makes a typo)
 	form.CheckField(validator.NotBlank(form.Email), "email", "this field cannot be blank")
 	form.CheckField(validator.Matches(form.Email, validator.EmailRx), "email", "this field must be a valid email address")
+	form.CheckField(validator.MaxChars(form.Email, maxThrottledEmailLength), "email", fmt.Sprintf("this field cannot be more than %d characters long", maxThrottledEmailLength))
 	form.CheckField(validator.NotBlank(form.Password), "password", "this field cannot be blank")
 
 	if !form.Valid() {
 		data := app.newTemplateData(r)
 		data.Form = form
-		app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		return
+	}
+
+	// count the attempt, and refuse to even check the password while this IP address or email
+	// has to wait after too many attempts. the message is the same whether or not the email has
+	// an account
+	wait, err := app.loginAttempt(r, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError("too many failed login attempts. please try again later")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "login.tmpl.html", data)
 		return
 	}
 
-	// check whether the credentials are valid. if they're not, add a generic non-field error message and re-display
-	// the login page
+	// check whether the credentials are valid. if they're not, add a generic non-field error
+	// message and re-display the login page. the attempt has already been counted
 	id, err := app.users.Authenticate(form.Email, form.Password)
 	if err != nil {
 		if errors.Is(err, models.ErrInvalidCredentials) {
@@ -224,31 +422,193 @@ func (app *application) userLoginPost(w http.ResponseWriter, r *http.Request) {
 
 			data := app.newTemplateData(r)
 			data.Form = form
-			app.render(w, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+			app.render(w, r, http.StatusUnprocessableEntity, "login.tmpl.html", data)
+		} else if errors.Is(err, models.ErrUserDisabled) {
+			// the password was right, so the attempts for the email are forgotten as usual
+			if err := app.loginSucceeded(r, form.Email); err != nil {
+				app.serverError(w, r, err)
+				return
+			}
+			form.AddNonFieldError("your account has been disabled")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusForbidden, "login.tmpl.html", data)
 		} else {
-			app.serverError(w, err)
+			app.serverError(w, r, err)
 		}
 		return
 	}
 
+	// users who have turned on two-factor authentication need to give a code as well
+	secret, err := app.twoFactor.Secret(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// use the RenewToken() method on the current session to change the session ID.
 	// its good practice to generate a new session ID when the authentication state or privilege levels changes for the user (e.g. login
 	// and logout operations)
 	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
+		return
+	}
+
+	// for those users we only remember that they got the password right, and for how long
+	// they have to enter their code. they aren't logged in until they have, so their login
+	// attempts aren't forgotten yet either. otherwise anybody who knew the password could
+	// keep starting again for a fresh set of guesses at the code
+	if secret != "" {
+		if err := app.loginPasswordAccepted(r, form.Email); err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorUserID", id)
+		app.sessionManager.Put(r.Context(), "twoFactorEmail", form.Email)
+		app.sessionManager.Put(r.Context(), "twoFactorExpires", app.now().Add(twoFactorLoginTimeout).Unix())
+		app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+		http.Redirect(w, r, "/user/login/verify", http.StatusSeeOther)
+		return
+	}
+
+	if err := app.loginSucceeded(r, form.Email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	err = app.startUserSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// struct to represent the form for the second step of logging in, which takes a TOTP code
+// or a recovery code
+type userLoginVerifyForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// handler to display the form for the second step of logging in
+func (app *application) userLoginVerify(w http.ResponseWriter, r *http.Request) {
+	if app.pendingTwoFactorUserID(r) == 0 {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userLoginVerifyForm{}
+	app.render(w, r, http.StatusOK, "verify.tmpl.html", data)
+}
+
+func (app *application) userLoginVerifyPost(w http.ResponseWriter, r *http.Request) {
+	id := app.pendingTwoFactorUserID(r)
+	if id == 0 {
+		app.sessionManager.Put(r.Context(), "flash", "your login timed out. please log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	var form userLoginVerifyForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Code), "code", "this field cannot be blank")
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "verify.tmpl.html", data)
+		return
+	}
+
+	// every guess at the code counts against the same limits as a guess at the password
+	email := app.sessionManager.GetString(r.Context(), "twoFactorEmail")
+	wait, err := app.loginAttempt(r, email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError("too many failed login attempts. please try again later")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "verify.tmpl.html", data)
+		return
+	}
+
+	usedRecoveryCode, ok, err := app.checkTwoFactorCode(id, form.Code)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !ok {
+		// only allow a few guesses before the user has to start again with their password
+		attempts := app.sessionManager.GetInt(r.Context(), "twoFactorAttempts") + 1
+		if attempts >= maxTwoFactorAttempts {
+			app.clearPendingTwoFactor(r)
+			app.sessionManager.Put(r.Context(), "flash", "too many incorrect codes. please log in again")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorAttempts", attempts)
+
+		form.AddNonFieldError("that code is incorrect")
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "verify.tmpl.html", data)
+		return
+	}
+
+	// the user is now fully logged in, so their login attempts can be forgotten, and like at
+	// the password step they get a new session ID
+	if err := app.loginSucceeded(r, email); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	app.clearPendingTwoFactor(r)
+	err = app.startUserSession(r, id)
+	if err != nil {
+		app.serverError(w, r, err)
 		return
 	}
 
-	app.sessionManager.Put(r.Context(), "authenticatedUserID", id)
+	if usedRecoveryCode {
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("you logged in with a recovery code. you have %d left", left))
+	}
 	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
 }
 
 func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
+	// forget the session in the user's list of sessions
+	err := app.userSessions.DeleteByToken(app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
 	// use the RenewToken() method on the current session to change the session ID again
-	err := app.sessionManager.RenewToken(r.Context())
+	err = app.sessionManager.RenewToken(r.Context())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -265,3 +625,731 @@ func (app *application) userLogoutPost(w http.ResponseWriter, r *http.Request) {
 func ping(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("OK"))
 }
+
+// struct to represent the form for creating an API token
+type tokenCreateForm struct {
+	Name                string `form:"name"`
+	Access              string `form:"access"`
+	Expires             int    `form:"expires"`
+	validator.Validator `form:"-"`
+}
+
+// handler to list the user's API tokens, along with a form to create a new one
+func (app *application) accountTokens(w http.ResponseWriter, r *http.Request) {
+	tokens, err := app.tokens.ForUser(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Tokens = tokens
+
+	// a token which was created on the previous request is shown once, and then it's gone
+	data.NewToken = app.sessionManager.PopString(r.Context(), "newToken")
+	data.Form = tokenCreateForm{
+		Access:  models.ScopeRead,
+		Expires: 90,
+	}
+	app.render(w, r, http.StatusOK, "tokens.tmpl.html", data)
+}
+
+// handler for creating an API token
+func (app *application) accountTokensPost(w http.ResponseWriter, r *http.Request) {
+	var form tokenCreateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Name), "name", "this field cannot be blank")
+	form.CheckField(validator.MaxChars(form.Name, 100), "name", "this field cannot be more than 100 characters long")
+	form.CheckField(validator.PermittedValue(form.Access, models.ScopeRead, models.ScopeWrite), "access", "this field must equal read or write")
+	form.CheckField(validator.PermittedValue(form.Expires, 0, 30, 90, 365), "expires", "this field must equal 0, 30, 90 or 365")
+
+	userID := app.authenticatedUserID(r)
+
+	if !form.Valid() {
+		tokens, err := app.tokens.ForUser(userID)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		data := app.newTemplateData(r)
+		data.Tokens = tokens
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "tokens.tmpl.html", data)
+		return
+	}
+
+	// a token which can write can always read too
+	scopes := []string{models.ScopeRead}
+	if form.Access == models.ScopeWrite {
+		scopes = append(scopes, models.ScopeWrite)
+	}
+
+	token, err := app.tokens.Insert(userID, form.Name, scopes, form.Expires)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// we only have the plain-text token now, so we keep it in the session to show on the next page
+	app.sessionManager.Put(r.Context(), "newToken", token)
+	app.sessionManager.Put(r.Context(), "flash", "token successfully created!")
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+// handler for revoking an API token
+func (app *application) accountTokensDeletePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	// Delete() only matches tokens belonging to the user, so somebody else's token is a 404
+	err = app.tokens.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "token successfully revoked!")
+	http.Redirect(w, r, "/account/tokens", http.StatusSeeOther)
+}
+
+// handler to show the logged in user their own account details
+func (app *application) accountView(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		// the user has been removed since they logged in, so send them to log in again
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	secret, err := app.twoFactor.Secret(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	sessions, err := app.userSessions.ForUser(user.ID)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.User = user
+	data.TwoFactorEnabled = secret != ""
+	data.Sessions = sessions
+	data.CurrentSessionID = app.userSessionID(r)
+	app.render(w, r, http.StatusOK, "account.tmpl.html", data)
+}
+
+// handler to log out one of the user's sessions, like the one on a lost laptop
+func (app *application) accountSessionsRevokePost(w http.ResponseWriter, r *http.Request) {
+	params := httprouter.ParamsFromContext(r.Context())
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	err = app.userSessions.Delete(app.authenticatedUserID(r), id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// revoking the session we're using is the same as logging out
+	if id == app.userSessionID(r) {
+		err = app.sessionManager.RenewToken(r.Context())
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+		app.sessionManager.Put(r.Context(), "flash", "you've been logged out successfully!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "the session has been logged out")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// handler to log out all of the user's sessions apart from the one they're using
+func (app *application) accountSessionsRevokeOthersPost(w http.ResponseWriter, r *http.Request) {
+	n, err := app.userSessions.DeleteAllExcept(app.authenticatedUserID(r), app.sessionManager.Token(r.Context()))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("logged out %d other session(s)", n))
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// struct to represent the change password form
+type accountPasswordUpdateForm struct {
+	CurrentPassword         string `form:"currentPassword"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+// handler to display the change password form
+func (app *application) accountPasswordUpdate(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = accountPasswordUpdateForm{}
+	app.render(w, r, http.StatusOK, "password.tmpl.html", data)
+}
+
+func (app *application) accountPasswordUpdatePost(w http.ResponseWriter, r *http.Request) {
+	var form accountPasswordUpdateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// the new password follows the same rules as at signup
+	form.CheckField(validator.NotBlank(form.CurrentPassword), "currentPassword", "this field cannot be blank")
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "this field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "this field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "this field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		return
+	}
+
+	err = app.users.PasswordUpdate(app.authenticatedUserID(r), form.CurrentPassword, form.NewPassword)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidCredentials) {
+			form.AddFieldError("currentPassword", "current password is incorrect")
+
+			data := app.newTemplateData(r)
+			data.Form = form
+			app.render(w, r, http.StatusUnprocessableEntity, "password.tmpl.html", data)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	// a password change is a change in the user's credentials, so every other session is
+	// logged out. this one is too, but like at login it gets a new ID and is logged straight
+	// back in
+	_, err = app.userSessions.DeleteAllExcept(app.authenticatedUserID(r), "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.sessionManager.RenewToken(r.Context())
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	err = app.startUserSession(r, app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "your password has been updated!")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// struct to represent the form for asking for a password reset link
+type userPasswordForgotForm struct {
+	Email               string `form:"email"`
+	validator.Validator `form:"-"`
+}
+
+// handler to display the form for asking for a password reset link
+func (app *application) userPasswordForgot(w http.ResponseWriter, r *http.Request) {
+	data := app.newTemplateData(r)
+	data.Form = userPasswordForgotForm{}
+	app.render(w, r, http.StatusOK, "forgot.tmpl.html", data)
+}
+
+func (app *application) userPasswordForgotPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordForgotForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Email), "email", "this field cannot be blank")
+	form.CheckField(validator.Matches(form.Email, validator.EmailRx), "email", "this field must be a valid email address")
+	form.CheckField(validator.MaxChars(form.Email, maxThrottledEmailLength), "email", fmt.Sprintf("this field cannot be more than %d characters long", maxThrottledEmailLength))
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "forgot.tmpl.html", data)
+		return
+	}
+
+	// count the request before looking the address up, so that the limit is the same whether
+	// or not there is an account for it
+	wait, err := app.resetAttempt(r, form.Email)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if wait > 0 {
+		setRetryAfter(w, wait)
+		form.AddNonFieldError("too many password reset requests. please try again later")
+
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusTooManyRequests, "forgot.tmpl.html", data)
+		return
+	}
+
+	// only send a link if there is an account for the address. either way we give the same
+	// answer, so that the form can't be used to find out who has an account
+	user, err := app.users.GetByEmail(form.Email)
+	if err != nil && !errors.Is(err, models.ErrNoRecord) {
+		app.serverError(w, r, err)
+		return
+	}
+	if user != nil {
+		token, err := app.passwordResets.Insert(user.ID, int(app.resetTTL/time.Minute))
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+
+		app.sendEmail(user.Email, "password_reset.tmpl", map[string]any{
+			"Name":      user.Name,
+			"ResetURL":  app.baseURL + "/user/password/reset?token=" + url.QueryEscape(token),
+			"ExpiresIn": humanDuration(app.resetTTL),
+		})
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "if there is an account for that address, we've emailed it a link to reset the password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// struct to represent the form for choosing a new password with a reset token
+type userPasswordResetForm struct {
+	Token                   string `form:"token"`
+	NewPassword             string `form:"newPassword"`
+	NewPasswordConfirmation string `form:"newPasswordConfirmation"`
+	validator.Validator     `form:"-"`
+}
+
+// handler to display the form for choosing a new password. the reset token comes from the
+// link in the email, and is carried in a hidden field of the form
+func (app *application) userPasswordReset(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	_, err := app.passwordResets.UserID(token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "that password reset link is invalid or has expired. please ask for a new one")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userPasswordResetForm{Token: token}
+	app.render(w, r, http.StatusOK, "reset.tmpl.html", data)
+}
+
+func (app *application) userPasswordResetPost(w http.ResponseWriter, r *http.Request) {
+	var form userPasswordResetForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.NewPassword), "newPassword", "this field cannot be blank")
+	form.CheckField(validator.MinChars(form.NewPassword, 8), "newPassword", "this field must be at least 8 characters long")
+	form.CheckField(validator.NotBlank(form.NewPasswordConfirmation), "newPasswordConfirmation", "this field cannot be blank")
+	form.CheckField(form.NewPassword == form.NewPasswordConfirmation, "newPasswordConfirmation", "passwords do not match")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		app.render(w, r, http.StatusUnprocessableEntity, "reset.tmpl.html", data)
+		return
+	}
+
+	// use up the token before changing the password, so that a link can't be used twice
+	userID, err := app.passwordResets.Consume(form.Token)
+	if err != nil {
+		if errors.Is(err, models.ErrInvalidToken) {
+			app.sessionManager.Put(r.Context(), "flash", "that password reset link is invalid or has expired. please ask for a new one")
+			http.Redirect(w, r, "/user/password/forgot", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	err = app.users.PasswordReset(userID, form.NewPassword)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// whoever had the old password may still be logged in somewhere, so log out everywhere
+	_, err = app.userSessions.DeleteAllExcept(userID, "")
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "your password has been reset. please log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// struct to represent the form for activating an account with an activation token
+type userActivateForm struct {
+	Token string `form:"token"`
+}
+
+// handler to display the page for activating an account. the activation token comes from the
+// link in the email and is carried in a hidden field, so that the account is only activated
+// by a POST request, and not by something which merely fetches the link
+func (app *application) userActivate(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+
+	if _, _, ok := app.verifyActivationToken(token); !ok {
+		app.activationLinkInvalid(w, r)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = userActivateForm{Token: token}
+	app.render(w, r, http.StatusOK, "activate.tmpl.html", data)
+}
+
+func (app *application) userActivatePost(w http.ResponseWriter, r *http.Request) {
+	var form userActivateForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id, email, ok := app.verifyActivationToken(form.Token)
+	if !ok {
+		app.activationLinkInvalid(w, r)
+		return
+	}
+
+	// the token proves that the link was sent to email. if the account has gone, or no longer
+	// has that address, the link is no good
+	user, err := app.users.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.activationLinkInvalid(w, r)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+	if user.Email != email {
+		app.activationLinkInvalid(w, r)
+		return
+	}
+
+	err = app.users.Activate(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "your account has been activated!")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// send the user back with a flash message when their activation link is no good
+func (app *application) activationLinkInvalid(w http.ResponseWriter, r *http.Request) {
+	app.sessionManager.Put(r.Context(), "flash", "that activation link is invalid or has expired. you can ask for a new one from your account page")
+	if app.isAuthenticated(r) {
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// handler to send the logged in user a new activation email. users can only ask for one
+// every activationResendInterval, so that the button can't be used to flood their inbox
+func (app *application) accountActivationResendPost(w http.ResponseWriter, r *http.Request) {
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return
+	}
+
+	if user.Activated {
+		app.sessionManager.Put(r.Context(), "flash", "your account is already activated")
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	sent, err := app.users.RecordActivationSent(user.ID, int(activationResendInterval/time.Second))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if !sent {
+		app.sessionManager.Put(r.Context(), "flash", fmt.Sprintf("we've sent you an activation email in the last %s. please check your inbox, or try again later", humanDuration(activationResendInterval)))
+		http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+		return
+	}
+
+	app.sendActivationEmail(user.ID, user.Name, user.Email)
+
+	app.sessionManager.Put(r.Context(), "flash", "we've emailed you a new activation link")
+	http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+}
+
+// handler to show the logged in user whether they have two-factor authentication turned on
+func (app *application) accountTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+
+	secret, err := app.twoFactor.Secret(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = accountTwoFactorDisableForm{}
+	data.TwoFactorEnabled = secret != ""
+	if data.TwoFactorEnabled {
+		data.RecoveryCodesLeft, err = app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+	}
+
+	// straight after two-factor authentication is turned on, the new recovery codes are
+	// passed here in the session so that they can be shown once
+	if codes := app.sessionManager.PopString(r.Context(), "recoveryCodes"); codes != "" {
+		data.RecoveryCodes = strings.Split(codes, "\n")
+	}
+
+	app.render(w, r, http.StatusOK, "twofactor.tmpl.html", data)
+}
+
+// struct to represent the form for confirming a new TOTP secret
+type accountTwoFactorEnableForm struct {
+	Code                string `form:"code"`
+	validator.Validator `form:"-"`
+}
+
+// handler to start turning on two-factor authentication. we make a new secret and keep it in
+// the session until the user proves their app has it, by entering a code
+func (app *application) accountTwoFactorEnable(w http.ResponseWriter, r *http.Request) {
+	secret, err := app.twoFactor.Secret(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if secret != "" {
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	pending := app.sessionManager.GetString(r.Context(), "twoFactorPendingSecret")
+	if pending == "" {
+		pending, err = totp.GenerateSecret()
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		app.sessionManager.Put(r.Context(), "twoFactorPendingSecret", pending)
+	}
+
+	data := app.newTemplateData(r)
+	data.Form = accountTwoFactorEnableForm{}
+	data.TwoFactorSecret = pending
+	app.render(w, r, http.StatusOK, "enroll.tmpl.html", data)
+}
+
+// handler to send the QR code for the secret being set up, as a PNG image. the image is made
+// here rather than by a third-party service, so the secret never leaves the server
+func (app *application) accountTwoFactorQR(w http.ResponseWriter, r *http.Request) {
+	pending := app.sessionManager.GetString(r.Context(), "twoFactorPendingSecret")
+	if pending == "" {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(app.authenticatedUserID(r))
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URI(totpIssuer, user.Email, pending), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(png)
+}
+
+func (app *application) accountTwoFactorEnablePost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+
+	// if two-factor authentication was turned on meanwhile, say from another tab, confirming
+	// this secret would quietly replace the one the user's app already has and the recovery
+	// codes they have written down
+	secret, err := app.twoFactor.Secret(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+	if secret != "" {
+		app.sessionManager.Remove(r.Context(), "twoFactorPendingSecret")
+		app.sessionManager.Put(r.Context(), "flash", "two-factor authentication is already on. turn it off first to set up a new device")
+		http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+		return
+	}
+
+	pending := app.sessionManager.GetString(r.Context(), "twoFactorPendingSecret")
+	if pending == "" {
+		http.Redirect(w, r, "/account/2fa/enable", http.StatusSeeOther)
+		return
+	}
+
+	var form accountTwoFactorEnableForm
+	err = app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	step, ok := totp.Validate(pending, form.Code, app.now())
+	form.CheckField(validator.NotBlank(form.Code), "code", "this field cannot be blank")
+	form.CheckField(ok, "code", "that code is incorrect. check that the clock on your device is right")
+
+	if !form.Valid() {
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.TwoFactorSecret = pending
+		app.render(w, r, http.StatusUnprocessableEntity, "enroll.tmpl.html", data)
+		return
+	}
+
+	codes, err := app.twoFactor.Enable(id, pending)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	// the code the user has just entered shouldn't be good for logging in as well
+	if _, err := app.twoFactor.UseStep(id, step); err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Remove(r.Context(), "twoFactorPendingSecret")
+	app.sessionManager.Put(r.Context(), "recoveryCodes", strings.Join(codes, "\n"))
+	app.sessionManager.Put(r.Context(), "flash", "two-factor authentication is now on!")
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
+
+// struct to represent the form for turning off two-factor authentication
+type accountTwoFactorDisableForm struct {
+	Password            string `form:"password"`
+	validator.Validator `form:"-"`
+}
+
+// handler to turn off two-factor authentication. the user has to enter their password, so that
+// somebody who finds them logged in can't weaken their account
+func (app *application) accountTwoFactorDisablePost(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUserID(r)
+
+	var form accountTwoFactorDisableForm
+	err := app.decodePostForm(r, &form)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	form.CheckField(validator.NotBlank(form.Password), "password", "this field cannot be blank")
+	if form.Valid() {
+		_, err = app.users.Authenticate(user.Email, form.Password)
+		if err != nil && !errors.Is(err, models.ErrInvalidCredentials) {
+			app.serverError(w, r, err)
+			return
+		}
+		form.CheckField(err == nil, "password", "password is incorrect")
+	}
+
+	if !form.Valid() {
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, r, err)
+			return
+		}
+		data := app.newTemplateData(r)
+		data.Form = form
+		data.TwoFactorEnabled = true
+		data.RecoveryCodesLeft = left
+		app.render(w, r, http.StatusUnprocessableEntity, "twofactor.tmpl.html", data)
+		return
+	}
+
+	err = app.twoFactor.Disable(id)
+	if err != nil {
+		app.serverError(w, r, err)
+		return
+	}
+
+	app.sessionManager.Put(r.Context(), "flash", "two-factor authentication is now off")
+	http.Redirect(w, r, "/account/2fa", http.StatusSeeOther)
+}
@@ -1,10 +1,22 @@
 package main
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/internal/models/mocks"
+	"snippetbox.lets-go/internal/totp"
 )
 
 func TestPing(t *testing.T) {
@@ -18,3 +30,1273 @@ func TestPing(t *testing.T) {
 	assert.Equal(t, code, http.StatusOK)
 	assert.Equal(t, respBody, "OK")
 }
+
+func TestSnippetView(t *testing.T) {
+	app := newTestApplication(t)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "Valid ID",
+			urlPath:  "/snippet/view/1",
+			wantCode: http.StatusOK,
+			wantBody: mocks.MockSnippet.Content,
+		},
+		{
+			name:     "Non-existent ID",
+			urlPath:  "/snippet/view/2",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Negative ID",
+			urlPath:  "/snippet/view/-1",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Decimal ID",
+			urlPath:  "/snippet/view/1.23",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "String ID",
+			urlPath:  "/snippet/view/foo",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "Empty ID",
+			urlPath:  "/snippet/view/",
+			wantCode: http.StatusNotFound,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantBody != "" {
+				assert.Equal(t, strings.Contains(body, tt.wantBody), true)
+			}
+		})
+	}
+}
+
+func TestSnippetDeleteModerator(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// the fixture snippet belongs to MockUser, so user 2 can't touch it to start with
+	ts.loginAs(t, mocks.DupeEmail, mocks.MockPassword)
+
+	_, _, body := ts.get(t, "/snippet/view/1")
+	assert.Equal(t, strings.Contains(body, "/snippet/delete/1"), false)
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ := ts.postForm(t, "/snippet/delete/1", form)
+	assert.Equal(t, code, http.StatusForbidden)
+
+	// once they are a moderator they can delete it, but still not edit it
+	err := app.users.SetRole(2, models.RoleModerator)
+	assert.NilError(t, err)
+
+	_, _, body = ts.get(t, "/snippet/view/1")
+	assert.Equal(t, strings.Contains(body, "/snippet/delete/1"), true)
+	assert.Equal(t, strings.Contains(body, "/snippet/edit/1"), false)
+	code, _, _ = ts.get(t, "/snippet/edit/1")
+	assert.Equal(t, code, http.StatusForbidden)
+
+	code, headers, _ := ts.postForm(t, "/snippet/delete/1", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/")
+	code, _, _ = ts.get(t, "/snippet/view/1")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestUserSignup(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// make a GET request first so that we have a CSRF token and cookie to send back
+	_, _, body := ts.get(t, "/user/signup")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	const (
+		validName     = "Bob"
+		validPassword = "validPa$$word"
+		validEmail    = "bob@example.com"
+		formTag       = `<form action="/user/signup" method="POST" novalidate>`
+	)
+
+	tests := []struct {
+		name         string
+		userName     string
+		userEmail    string
+		userPassword string
+		csrfToken    string
+		wantCode     int
+		wantFormTag  string
+	}{
+		{
+			name:         "Valid submission",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusSeeOther,
+		},
+		{
+			name:         "Invalid CSRF Token",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    "wrongToken",
+			wantCode:     http.StatusBadRequest,
+		},
+		{
+			name:         "Empty name",
+			userName:     "",
+			userEmail:    validEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Invalid email",
+			userName:     validName,
+			userEmail:    "bob@example.",
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Short password",
+			userName:     validName,
+			userEmail:    validEmail,
+			userPassword: "pa$$",
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+		{
+			name:         "Duplicate email",
+			userName:     validName,
+			userEmail:    mocks.DupeEmail,
+			userPassword: validPassword,
+			csrfToken:    validCSRFToken,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantFormTag:  formTag,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.userEmail)
+			form.Add("password", tt.userPassword)
+			form.Add("csrf_token", tt.csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/signup", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantFormTag != "" {
+				assert.Equal(t, strings.Contains(body, tt.wantFormTag), true)
+			}
+		})
+	}
+}
+
+func TestUserLogin(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	tests := []struct {
+		name         string
+		userEmail    string
+		userPassword string
+		wantCode     int
+		wantLocation string
+		wantBody     string
+	}{
+		{
+			name:         "Wrong password",
+			userEmail:    mocks.MockUser.Email,
+			userPassword: "wrongPa$$word",
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "email or password is incorrect",
+		},
+		{
+			name:         "Unknown email",
+			userEmail:    "nobody@example.com",
+			userPassword: mocks.MockPassword,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "email or password is incorrect",
+		},
+		{
+			name:         "Blank email",
+			userEmail:    "",
+			userPassword: mocks.MockPassword,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "this field cannot be blank",
+		},
+		{
+			name:         "Long email",
+			userEmail:    strings.Repeat("a", 240) + "@example.com",
+			userPassword: mocks.MockPassword,
+			wantCode:     http.StatusUnprocessableEntity,
+			wantBody:     "this field cannot be more than 243 characters long",
+		},
+		{
+			name:         "Valid credentials",
+			userEmail:    mocks.MockUser.Email,
+			userPassword: mocks.MockPassword,
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/snippet/create",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("email", tt.userEmail)
+			form.Add("password", tt.userPassword)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, header, body := ts.postForm(t, "/user/login", form)
+
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, header.Get("Location"), tt.wantLocation)
+			if tt.wantBody != "" {
+				assert.Equal(t, strings.Contains(body, tt.wantBody), true)
+			}
+		})
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	login := func(email, password string) (int, http.Header, string) {
+		t.Helper()
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	// the first few failures cost nothing
+	for i := 0; i < 4; i++ {
+		code, _, _ := login(mocks.MockUser.Email, "wrongPa$$word")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+	}
+
+	// then even the right password has to wait, and the response doesn't give away that
+	// it was right
+	code, headers, body := login(mocks.MockUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "1")
+	assert.Equal(t, strings.Contains(body, "too many failed login attempts"), true)
+
+	// emails without an account are throttled in just the same way
+	for i := 0; i < 4; i++ {
+		login("nobody@example.com", "wrongPa$$word")
+	}
+	code, headers, body = login("nobody@example.com", mocks.MockPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "1")
+	assert.Equal(t, strings.Contains(body, "too many failed login attempts"), true)
+
+	// once the wait is over the right password works, and clears the failures for the email
+	now = now.Add(time.Second)
+	code, headers, _ = login(mocks.MockUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+	ts.logout(t)
+
+	_, _, body = ts.get(t, "/user/login")
+	csrfToken = extractCSRFToken(t, body)
+
+	// each failure after the free ones doubles the wait, until the email is locked out
+	for i := 0; i < 9; i++ {
+		code, _, _ = login(mocks.MockUser.Email, "wrongPa$$word")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		now = now.Add(time.Minute)
+	}
+	code, _, _ = login(mocks.MockUser.Email, "wrongPa$$word")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	code, headers, _ = login(mocks.MockUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "900")
+
+	now = now.Add(15 * time.Minute)
+	code, _, _ = login(mocks.MockUser.Email, mocks.MockPassword)
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+// a UserModelInterface which counts the password checks it is asked to do
+type countingUsers struct {
+	models.UserModelInterface
+	authenticated atomic.Int32
+}
+
+func (u *countingUsers) Authenticate(email, password string) (int, error) {
+	u.authenticated.Add(1)
+	return u.UserModelInterface.Authenticate(email, password)
+}
+
+func TestLoginThrottleConcurrent(t *testing.T) {
+	app := newTestApplication(t)
+	users := &countingUsers{UserModelInterface: app.users}
+	app.users = users
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", mocks.MockUser.Email)
+	form.Add("password", "wrongPa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	// send a burst of wrong passwords all at once. checking first and counting the failures
+	// afterwards would let every one of them through to a password check
+	const requests = 30
+	var (
+		wg      sync.WaitGroup
+		refused atomic.Int32
+	)
+	for i := 0; i < requests; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			code, _, _ := ts.postForm(t, "/user/login", form)
+			switch code {
+			case http.StatusTooManyRequests:
+				refused.Add(1)
+			case http.StatusUnprocessableEntity:
+			default:
+				t.Errorf("got status %d", code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the email has three free attempts, and then one more can go ahead
+	checked := int(users.authenticated.Load())
+	assert.Equal(t, checked >= 3 && checked <= 4, true)
+	assert.Equal(t, int(refused.Load()), requests-checked)
+}
+
+func TestSnippetCreate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	t.Run("Unauthenticated", func(t *testing.T) {
+		code, headers, _ := ts.get(t, "/snippet/create")
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	ts.login(t)
+
+	t.Run("Authenticated", func(t *testing.T) {
+		code, _, body := ts.get(t, "/snippet/create")
+
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, `<form action="/snippet/create" method="POST">`), true)
+	})
+
+	t.Run("Valid submission", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/create")
+
+		form := url.Values{}
+		form.Add("title", "O snail")
+		form.Add("content", "O snail\nClimb Mount Fuji,\nBut slowly, slowly!")
+		form.Add("expires", "7")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, headers, _ := ts.postForm(t, "/snippet/create", form)
+
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/view/2")
+
+		// the new snippet should be viewable and credited to the logged in user
+		code, _, body = ts.get(t, "/snippet/view/2")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, mocks.MockUser.Name), true)
+	})
+
+	t.Run("Invalid submission", func(t *testing.T) {
+		_, _, body := ts.get(t, "/snippet/create")
+
+		form := url.Values{}
+		form.Add("title", "")
+		form.Add("content", "content")
+		form.Add("expires", "2")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+
+		code, _, body := ts.postForm(t, "/snippet/create", form)
+
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.Equal(t, strings.Contains(body, "this field cannot be blank"), true)
+		assert.Equal(t, strings.Contains(body, "this field must equal 1, 7, or 365"), true)
+	})
+}
+
+// post an edit of the fixture snippet, taking the CSRF token from page
+func postSnippetEdit(t *testing.T, ts *testServer, urlPath, page, title, expires string) (int, http.Header, string) {
+	_, _, body := ts.get(t, page)
+
+	form := url.Values{}
+	form.Add("title", title)
+	form.Add("content", "An old silent pond, edited...")
+	form.Add("expires", expires)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	return ts.postForm(t, urlPath, form)
+}
+
+func TestSnippetEdit(t *testing.T) {
+	t.Run("Anonymous", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+
+		code, headers, _ := ts.get(t, "/snippet/edit/1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		code, headers, _ = postSnippetEdit(t, ts, "/snippet/edit/1", "/user/login", "Defaced", "0")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	t.Run("Not the author", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.loginAs(t, mocks.DupeEmail, mocks.MockPassword)
+
+		code, _, _ := ts.get(t, "/snippet/edit/1")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		code, _, _ = postSnippetEdit(t, ts, "/snippet/edit/1", "/", "Defaced", "0")
+		assert.Equal(t, code, http.StatusForbidden)
+
+		s, err := app.snippets.Get(1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Title, mocks.MockSnippet.Title)
+	})
+
+	t.Run("Author", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.login(t)
+
+		// the form starts with the current contents, and with the expiry kept as it is
+		code, _, body := ts.get(t, "/snippet/edit/1")
+		assert.Equal(t, code, http.StatusOK)
+		assert.Equal(t, strings.Contains(body, `<form action="/snippet/edit/1" method="POST">`), true)
+		assert.Equal(t, strings.Contains(body, mocks.MockSnippet.Title), true)
+		assert.Equal(t, strings.Contains(body, `value="0" checked`), true)
+
+		code, headers, _ := postSnippetEdit(t, ts, "/snippet/edit/1", "/snippet/edit/1", "A new silent pond", "0")
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/view/1")
+
+		s, err := app.snippets.Get(1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Title, "A new silent pond")
+		assert.Equal(t, s.Expires.Equal(mocks.MockSnippet.Expires), true)
+
+		// picking a new expiry counts from now
+		code, _, _ = postSnippetEdit(t, ts, "/snippet/edit/1", "/snippet/edit/1", "A new silent pond", "1")
+		assert.Equal(t, code, http.StatusSeeOther)
+		s, err = app.snippets.Get(1)
+		assert.NilError(t, err)
+		assert.Equal(t, s.Expires.Before(time.Now().Add(25*time.Hour)), true)
+	})
+
+	t.Run("Invalid submission", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.login(t)
+
+		code, _, body := postSnippetEdit(t, ts, "/snippet/edit/1", "/snippet/edit/1", "", "2")
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.Equal(t, strings.Contains(body, `<form action="/snippet/edit/1" method="POST">`), true)
+		assert.Equal(t, strings.Contains(body, "this field cannot be blank"), true)
+		assert.Equal(t, strings.Contains(body, "this field must equal 0, 1, 7, or 365"), true)
+	})
+
+	t.Run("Missing snippet", func(t *testing.T) {
+		app := newTestApplication(t)
+		ts := newTestServer(t, app.routes())
+		defer ts.Close()
+		ts.login(t)
+
+		code, _, _ := ts.get(t, "/snippet/edit/99")
+		assert.Equal(t, code, http.StatusNotFound)
+
+		code, _, _ = postSnippetEdit(t, ts, "/snippet/edit/99", "/", "Ghost", "0")
+		assert.Equal(t, code, http.StatusNotFound)
+	})
+}
+
+func TestSnippetDelete(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string // who to log in as, if anyone
+		urlPath      string
+		wantCode     int
+		wantLocation string
+		wantGone     bool
+	}{
+		{
+			name:         "Anonymous",
+			urlPath:      "/snippet/delete/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/user/login",
+		},
+		{
+			name:     "Not the author",
+			email:    mocks.DupeEmail,
+			urlPath:  "/snippet/delete/1",
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "Missing snippet",
+			email:    mocks.MockUser.Email,
+			urlPath:  "/snippet/delete/99",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:         "Author",
+			email:        mocks.MockUser.Email,
+			urlPath:      "/snippet/delete/1",
+			wantCode:     http.StatusSeeOther,
+			wantLocation: "/",
+			wantGone:     true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			page := "/user/login"
+			if tt.email != "" {
+				ts.loginAs(t, tt.email, mocks.MockPassword)
+				page = "/"
+			}
+			_, _, body := ts.get(t, page)
+			form := url.Values{}
+			form.Add("csrf_token", extractCSRFToken(t, body))
+
+			code, headers, _ := ts.postForm(t, tt.urlPath, form)
+			assert.Equal(t, code, tt.wantCode)
+			assert.Equal(t, headers.Get("Location"), tt.wantLocation)
+
+			_, err := app.snippets.Get(1)
+			assert.Equal(t, errors.Is(err, models.ErrNoRecord), tt.wantGone)
+		})
+	}
+}
+
+func TestAccountTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// the page is only for logged in users
+	code, headers, _ := ts.get(t, "/account/tokens")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t)
+
+	_, _, body := ts.get(t, "/account/tokens")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("name", "deploy")
+	form.Add("access", "admin")
+	form.Add("expires", "30")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/account/tokens", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, strings.Contains(body, "this field must equal read or write"), true)
+
+	form.Set("access", "write")
+	code, headers, _ = ts.postForm(t, "/account/tokens", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/tokens")
+
+	// the new token is shown once, and works against the API
+	_, _, body = ts.get(t, "/account/tokens")
+	assert.Equal(t, strings.Contains(body, "sbx_mocktoken4"), true)
+	_, _, body = ts.get(t, "/account/tokens")
+	assert.Equal(t, strings.Contains(body, "sbx_mocktoken4"), false)
+
+	code, _, _ = ts.request(t, http.MethodPost, "/api/v1/snippets", `{"title": "t", "content": "c", "expires": 1}`, "sbx_mocktoken4")
+	assert.Equal(t, code, http.StatusCreated)
+
+	// revoke it, and it stops working. tokens belonging to somebody else can't be revoked
+	form = url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/account/tokens/delete/3", form)
+	assert.Equal(t, code, http.StatusNotFound)
+	code, _, _ = ts.postForm(t, "/account/tokens/delete/4", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = ts.request(t, http.MethodPost, "/api/v1/snippets", `{"title": "t", "content": "c", "expires": 1}`, "sbx_mocktoken4")
+	assert.Equal(t, code, http.StatusUnauthorized)
+}
+
+func TestAccountView(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, _ := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	ts.login(t)
+
+	code, _, body := ts.get(t, "/account/view")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, mocks.MockUser.Name), true)
+	assert.Equal(t, strings.Contains(body, mocks.MockUser.Email), true)
+}
+
+func TestAccountSessions(t *testing.T) {
+	app := newTestApplication(t)
+
+	// two clients with their own cookies, logged in as the same user
+	laptop := newTestServer(t, app.routes())
+	defer laptop.Close()
+	phone := newTestServer(t, app.routes())
+	defer phone.Close()
+
+	laptop.login(t)
+	phone.login(t)
+
+	_, _, body := laptop.get(t, "/account/view")
+	assert.Equal(t, strings.Count(body, "/account/sessions/revoke/"), 2)
+	assert.Equal(t, strings.Count(body, "(this session)"), 1)
+	assert.Equal(t, strings.Contains(body, "Log out all other sessions"), true)
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	// sessions belonging to somebody else can't be revoked
+	code, _, _ := laptop.postForm(t, "/account/sessions/revoke/99", form)
+	assert.Equal(t, code, http.StatusNotFound)
+
+	// the phone was the second session to log in
+	code, headers, _ := laptop.postForm(t, "/account/sessions/revoke/2", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	code, headers, _ = phone.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	code, _, _ = laptop.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	phone.login(t)
+	code, _, _ = laptop.postForm(t, "/account/sessions/revoke-others", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = laptop.get(t, "/account/view")
+	assert.Equal(t, strings.Contains(body, "logged out 1 other session(s)"), true)
+	code, _, _ = phone.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	// changing the password logs out every other session, but not the one that changed it
+	phone.login(t)
+	_, _, body = laptop.get(t, "/account/password/update")
+	form = url.Values{}
+	form.Add("currentPassword", mocks.MockPassword)
+	form.Add("newPassword", "n3w pa$$word")
+	form.Add("newPasswordConfirmation", "n3w pa$$word")
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = laptop.postForm(t, "/account/password/update", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+
+	code, _, _ = phone.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	code, _, _ = laptop.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	// logging out the session we're using is the same as logging out
+	_, _, body = laptop.get(t, "/account/view")
+	assert.Equal(t, strings.Count(body, "/account/sessions/revoke/"), 1)
+	id := regexp.MustCompile(`/account/sessions/revoke/(\d+)`).FindStringSubmatch(body)[1]
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, headers, _ = laptop.postForm(t, "/account/sessions/revoke/"+id, form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/")
+	code, _, _ = laptop.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+}
+
+func TestAccountPasswordUpdate(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t)
+	_, _, body := ts.get(t, "/account/password/update")
+	validCSRFToken := extractCSRFToken(t, body)
+
+	const newPassword = "n3w pa$$word"
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		confirmation    string
+		wantCode        int
+		wantError       string
+	}{
+		{
+			name:            "Wrong current password",
+			currentPassword: "wrong",
+			newPassword:     newPassword,
+			confirmation:    newPassword,
+			wantCode:        http.StatusUnprocessableEntity,
+			wantError:       "current password is incorrect",
+		},
+		{
+			name:            "Short new password",
+			currentPassword: mocks.MockPassword,
+			newPassword:     "short",
+			confirmation:    "short",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantError:       "this field must be at least 8 characters long",
+		},
+		{
+			name:            "Mismatched confirmation",
+			currentPassword: mocks.MockPassword,
+			newPassword:     newPassword,
+			confirmation:    newPassword + "!",
+			wantCode:        http.StatusUnprocessableEntity,
+			wantError:       "passwords do not match",
+		},
+		{
+			name:            "Valid submission",
+			currentPassword: mocks.MockPassword,
+			newPassword:     newPassword,
+			confirmation:    newPassword,
+			wantCode:        http.StatusSeeOther,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("currentPassword", tt.currentPassword)
+			form.Add("newPassword", tt.newPassword)
+			form.Add("newPasswordConfirmation", tt.confirmation)
+			form.Add("csrf_token", validCSRFToken)
+
+			code, headers, body := ts.postForm(t, "/account/password/update", form)
+			assert.Equal(t, code, tt.wantCode)
+			if tt.wantError != "" {
+				assert.Equal(t, strings.Contains(body, tt.wantError), true)
+			}
+			if code == http.StatusSeeOther {
+				assert.Equal(t, headers.Get("Location"), "/account/view")
+			}
+		})
+	}
+
+	// the new password is the one which works now
+	_, err := app.users.Authenticate(mocks.MockUser.Email, newPassword)
+	assert.NilError(t, err)
+}
+
+func TestPasswordReset(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := useMemoryOutbox(t, app)
+	var logs bytes.Buffer
+	app.logger = newLogger(&logs, "text", "info")
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	// an unknown address gets the same answer as a known one, but no email
+	form := url.Values{}
+	form.Add("email", "nobody@example.com")
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/user/password/forgot", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+	app.wg.Wait()
+	assert.Equal(t, len(outbox.Messages()), 0)
+
+	form.Set("email", mocks.MockUser.Email)
+	code, _, _ = ts.postForm(t, "/user/password/forgot", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	app.wg.Wait()
+
+	messages := outbox.Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].To, mocks.MockUser.Email)
+	assert.Equal(t, messages[0].Subject, "Reset your Snippetbox password")
+
+	const resetURL = "https://snippetbox.test/user/password/reset?token=reset1"
+	assert.Equal(t, strings.Contains(messages[0].PlainBody, resetURL), true)
+	assert.Equal(t, strings.Contains(messages[0].PlainBody, "stops working in 1 hour"), true)
+	assert.Equal(t, strings.Contains(messages[0].HTMLBody, `<a href="`+resetURL+`">`), true)
+
+	// a made up token is sent back to ask for a new link
+	code, headers, _ = ts.get(t, "/user/password/reset?token=wrong")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/password/forgot")
+
+	code, _, body = ts.get(t, "/user/password/reset?token=reset1")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, `<input type="hidden" name="token" value="reset1">`), true)
+
+	const newPassword = "n3w pa$$word"
+	form = url.Values{}
+	form.Add("token", "reset1")
+	form.Add("newPassword", newPassword)
+	form.Add("newPasswordConfirmation", "something else")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/user/password/reset", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, strings.Contains(body, "passwords do not match"), true)
+
+	form.Set("newPasswordConfirmation", newPassword)
+	code, headers, _ = ts.postForm(t, "/user/password/reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	_, err := app.users.Authenticate(mocks.MockUser.Email, newPassword)
+	assert.NilError(t, err)
+
+	// the link only works once
+	code, headers, _ = ts.postForm(t, "/user/password/reset", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/password/forgot")
+	// the token was in the URL of a request, but it never makes it into the logs
+	assert.Equal(t, strings.Contains(logs.String(), `uri="/user/password/reset?token=REDACTED"`), true)
+	assert.Equal(t, strings.Contains(logs.String(), "reset1"), false)
+}
+
+func TestPasswordResetThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := useMemoryOutbox(t, app)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	_, _, body := ts.get(t, "/user/password/forgot")
+	csrfToken := extractCSRFToken(t, body)
+
+	forgot := func(email string) (int, http.Header, string) {
+		t.Helper()
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/password/forgot", form)
+	}
+
+	// an address gets a few links, and then has to wait before the next one
+	for i := 0; i < 4; i++ {
+		code, _, _ := forgot(mocks.MockUser.Email)
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+	code, headers, body := forgot(strings.ToUpper(mocks.MockUser.Email))
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "300")
+	assert.Equal(t, strings.Contains(body, "too many password reset requests"), true)
+
+	app.wg.Wait()
+	assert.Equal(t, len(outbox.Messages()), 4)
+
+	// addresses without an account are limited in just the same way, so the limit doesn't
+	// give away who has one
+	for i := 0; i < 4; i++ {
+		forgot("nobody@example.com")
+	}
+	code, _, _ = forgot("nobody@example.com")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+
+	// once the wait is over there can be another link
+	now = now.Add(5 * time.Minute)
+	code, _, _ = forgot(mocks.MockUser.Email)
+	assert.Equal(t, code, http.StatusSeeOther)
+	app.wg.Wait()
+	assert.Equal(t, len(outbox.Messages()), 5)
+
+	// an IP address asking for links to lots of addresses is limited too
+	now = now.Add(24 * time.Hour)
+	for i := 0; i < 11; i++ {
+		code, _, _ = forgot(fmt.Sprintf("user%d@example.com", i))
+		assert.Equal(t, code, http.StatusSeeOther)
+	}
+	code, headers, _ = forgot("user99@example.com")
+	assert.Equal(t, code, http.StatusTooManyRequests)
+	assert.Equal(t, headers.Get("Retry-After"), "60")
+
+	// an address too long for the throttle table is turned away before it is counted
+	code, _, body = forgot(strings.Repeat("a", 240) + "@example.com")
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, strings.Contains(body, "this field cannot be more than 243 characters long"), true)
+}
+
+func TestUserActivation(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := useMemoryOutbox(t, app)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/signup")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("name", "Bob")
+	form.Add("email", "bob@example.com")
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/signup", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	app.wg.Wait()
+
+	messages := outbox.Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].To, "bob@example.com")
+	assert.Equal(t, messages[0].Subject, "Activate your Snippetbox account")
+	assert.Equal(t, strings.Contains(messages[0].PlainBody, "stops working in 3 days"), true)
+
+	matches := regexp.MustCompile(`https://snippetbox\.test/user/activate\?token=(\S+)`).FindStringSubmatch(messages[0].PlainBody)
+	if matches == nil {
+		t.Fatalf("no activation link in email: %q", messages[0].PlainBody)
+	}
+	token := matches[1]
+
+	// until they follow the link, the new user can't create snippets
+	ts.loginAs(t, "bob@example.com", "validPa$$word")
+	code, headers, _ := ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	_, _, body = ts.get(t, "/account/view")
+	assert.Equal(t, strings.Contains(body, "please activate your account"), true)
+	assert.Equal(t, strings.Contains(body, "Resend activation email"), true)
+
+	// the signup email was only just sent, so asking for another is throttled
+	form = url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, _, _ = ts.postForm(t, "/account/activation/resend", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = ts.get(t, "/account/view")
+	assert.Equal(t, strings.Contains(body, "in the last 5 minutes"), true)
+	app.wg.Wait()
+	assert.Equal(t, len(outbox.Messages()), 1)
+
+	// a tampered link is turned away
+	code, headers, _ = ts.get(t, "/user/activate?token="+token+"x")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+
+	// following the link shows a button rather than activating straight away
+	code, _, body = ts.get(t, "/user/activate?token="+token)
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, `<input type="hidden" name="token" value="`+token+`">`), true)
+
+	form = url.Values{}
+	form.Add("token", token)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, headers, _ = ts.postForm(t, "/user/activate", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+	code, _, body = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, strings.Contains(body, "your account has been activated!"), true)
+}
+
+func TestAccountActivationResend(t *testing.T) {
+	app := newTestApplication(t)
+	outbox := useMemoryOutbox(t, app)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.loginAs(t, mocks.MockInactiveUser.Email, mocks.MockPassword)
+	_, _, body := ts.get(t, "/account/view")
+
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, headers, _ := ts.postForm(t, "/account/activation/resend", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/view")
+	app.wg.Wait()
+
+	messages := outbox.Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].To, mocks.MockInactiveUser.Email)
+
+	// the second request inside the interval doesn't send anything
+	code, _, _ = ts.postForm(t, "/account/activation/resend", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	app.wg.Wait()
+	assert.Equal(t, len(outbox.Messages()), 1)
+}
+
+func TestTwoFactor(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// a fake clock, so that we know which TOTP codes are valid
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	ts.login(t)
+
+	_, _, body := ts.get(t, "/account/2fa")
+	assert.Equal(t, strings.Contains(body, "Two-factor authentication is off"), true)
+
+	// the secret being set up is shown for typing in by hand, and as a QR code
+	code, _, body := ts.get(t, "/account/2fa/enable")
+	assert.Equal(t, code, http.StatusOK)
+	matches := regexp.MustCompile(`<pre><code>([A-Z2-7]+)</code></pre>`).FindStringSubmatch(body)
+	if matches == nil {
+		t.Fatal("no secret found in body")
+	}
+	secret := matches[1]
+	csrfToken := extractCSRFToken(t, body)
+
+	code, headers, body := ts.get(t, "/account/2fa/qr.png")
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "image/png")
+	assert.Equal(t, strings.HasPrefix(body, "\x89PNG"), true)
+
+	form := url.Values{}
+	form.Add("code", "000000")
+	form.Add("csrf_token", csrfToken)
+	code, _, body = ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, strings.Contains(body, "that code is incorrect"), true)
+
+	totpCode, err := totp.Code(secret, now)
+	assert.NilError(t, err)
+	form.Set("code", totpCode)
+	code, headers, _ = ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/2fa")
+
+	// the recovery codes are shown once
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.Equal(t, strings.Contains(body, "recovery1"), true)
+	assert.Equal(t, strings.Contains(body, "You have 10 unused recovery codes"), true)
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.Equal(t, strings.Contains(body, "recovery1"), false)
+
+	ts.logout(t)
+
+	// now the password alone doesn't log the user in
+	_, _, body = ts.get(t, "/user/login")
+	form = url.Values{}
+	form.Add("email", mocks.MockUser.Email)
+	form.Add("password", mocks.MockPassword)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+	code, headers, _ = ts.postForm(t, "/user/login", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login/verify")
+
+	code, headers, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/user/login")
+
+	code, _, body = ts.get(t, "/user/login/verify")
+	assert.Equal(t, code, http.StatusOK)
+	verifyForm := url.Values{}
+	verifyForm.Add("csrf_token", extractCSRFToken(t, body))
+
+	// the code which was used to turn on two-factor authentication can't be used again
+	verifyForm.Set("code", totpCode)
+	code, _, body = ts.postForm(t, "/user/login/verify", verifyForm)
+	assert.Equal(t, code, http.StatusUnprocessableEntity)
+	assert.Equal(t, strings.Contains(body, "that code is incorrect"), true)
+
+	now = now.Add(totp.Period)
+	totpCode, err = totp.Code(secret, now)
+	assert.NilError(t, err)
+	verifyForm.Set("code", totpCode)
+	code, headers, _ = ts.postForm(t, "/user/login/verify", verifyForm)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+	code, _, _ = ts.get(t, "/snippet/create")
+	assert.Equal(t, code, http.StatusOK)
+
+	// log in again with a recovery code
+	ts.logout(t)
+	ts.login(t)
+	verifyForm.Set("code", "recovery1")
+	code, _, _ = ts.postForm(t, "/user/login/verify", verifyForm)
+	assert.Equal(t, code, http.StatusSeeOther)
+	_, _, body = ts.get(t, "/snippet/create")
+	assert.Equal(t, strings.Contains(body, "you logged in with a recovery code. you have 9 left"), true)
+
+	t.Run("Too many attempts", func(t *testing.T) {
+		ts.logout(t)
+		ts.login(t)
+		verifyForm.Set("code", "000000")
+		for i := 1; i < maxTwoFactorAttempts; i++ {
+			// far enough apart that the login throttle doesn't step in first
+			now = now.Add(10 * time.Second)
+			code, _, _ := ts.postForm(t, "/user/login/verify", verifyForm)
+			assert.Equal(t, code, http.StatusUnprocessableEntity)
+		}
+		now = now.Add(10 * time.Second)
+		code, headers, _ := ts.postForm(t, "/user/login/verify", verifyForm)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+
+		// the user has to start again with their password
+		code, _, _ = ts.get(t, "/user/login/verify")
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		// but that doesn't give them a fresh set of guesses, since the wrong codes were counted
+		// by the login throttle, and getting the password right didn't clear them
+		now = now.Add(10 * time.Second)
+		ts.login(t)
+		code, headers, body := ts.postForm(t, "/user/login/verify", verifyForm)
+		assert.Equal(t, code, http.StatusTooManyRequests)
+		assert.Equal(t, headers.Get("Retry-After"), "8")
+		assert.Equal(t, strings.Contains(body, "too many failed login attempts"), true)
+
+		// the right code, once the wait is over, clears them
+		now = now.Add(totp.Period)
+		totpCode, err := totp.Code(secret, now)
+		assert.NilError(t, err)
+		verifyForm.Set("code", totpCode)
+		code, headers, _ = ts.postForm(t, "/user/login/verify", verifyForm)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+		ts.logout(t)
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		ts.login(t)
+		now = now.Add(twoFactorLoginTimeout)
+		totpCode, err := totp.Code(secret, now)
+		assert.NilError(t, err)
+		verifyForm.Set("code", totpCode)
+
+		code, headers, _ := ts.postForm(t, "/user/login/verify", verifyForm)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/user/login")
+	})
+
+	t.Run("Disable", func(t *testing.T) {
+		ts.login(t)
+		now = now.Add(totp.Period)
+		totpCode, err := totp.Code(secret, now)
+		assert.NilError(t, err)
+		verifyForm.Set("code", totpCode)
+		code, headers, _ := ts.postForm(t, "/user/login/verify", verifyForm)
+		assert.Equal(t, code, http.StatusSeeOther)
+		assert.Equal(t, headers.Get("Location"), "/snippet/create")
+
+		_, _, body := ts.get(t, "/account/2fa")
+		form := url.Values{}
+		form.Add("password", "wrong")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, body = ts.postForm(t, "/account/2fa/disable", form)
+		assert.Equal(t, code, http.StatusUnprocessableEntity)
+		assert.Equal(t, strings.Contains(body, "password is incorrect"), true)
+
+		form.Set("password", mocks.MockPassword)
+		code, _, _ = ts.postForm(t, "/account/2fa/disable", form)
+		assert.Equal(t, code, http.StatusSeeOther)
+
+		ts.logout(t)
+		ts.login(t)
+		code, _, _ = ts.get(t, "/snippet/create")
+		assert.Equal(t, code, http.StatusOK)
+	})
+}
+
+func TestTwoFactorEnableTwice(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	app.now = func() time.Time { return now }
+
+	ts.login(t)
+
+	_, _, body := ts.get(t, "/account/2fa/enable")
+	matches := regexp.MustCompile(`<pre><code>([A-Z2-7]+)</code></pre>`).FindStringSubmatch(body)
+	if matches == nil {
+		t.Fatal("no secret found in body")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	// meanwhile two-factor authentication is turned on from another browser
+	const other = "JBSWY3DPEHPK3PXP"
+	_, err := app.twoFactor.Enable(mocks.MockUser.ID, other)
+	assert.NilError(t, err)
+
+	totpCode, err := totp.Code(matches[1], now)
+	assert.NilError(t, err)
+	form := url.Values{}
+	form.Add("code", totpCode)
+	form.Add("csrf_token", csrfToken)
+	code, headers, _ := ts.postForm(t, "/account/2fa/enable", form)
+	assert.Equal(t, code, http.StatusSeeOther)
+	assert.Equal(t, headers.Get("Location"), "/account/2fa")
+
+	// the secret and the recovery codes from the other browser are kept
+	secret, err := app.twoFactor.Secret(mocks.MockUser.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, other)
+	_, _, body = ts.get(t, "/account/2fa")
+	assert.Equal(t, strings.Contains(body, "two-factor authentication is already on"), true)
+	assert.Equal(t, strings.Contains(body, "recovery1"), false)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+)
+
+// how long each readiness check gets before it counts as failed
+const readinessTimeout = 2 * time.Second
+
+// the session token we look up to check that the session store is working. it never exists,
+// so the store only has to run the query and find nothing
+const readinessSessionToken = "readyz-probe"
+
+// the database, as far as the readiness check cares. *sql.DB satisfies it
+type pinger interface {
+	PingContext(ctx context.Context) error
+}
+
+// the result of one readiness check, as it appears in the /readyz response
+type checkResult struct {
+	Status   string `json:"status"` // "ok", "error" or "timeout"
+	Duration string `json:"duration,omitempty"`
+	Count    int    `json:"count,omitempty"`
+}
+
+// handler for /healthz. it only says that the process is up and serving requests, so that
+// an orchestrator can restart us if it isn't. it deliberately doesn't check the database,
+// since restarting the app wouldn't fix a database which is down
+func (app *application) healthz(w http.ResponseWriter, r *http.Request) {
+	app.writeJSON(w, r, http.StatusOK, envelope{"status": "ok"}, nil)
+}
+
+// handler for /readyz, which says whether we should be sent traffic. that needs the database
+// and the session store to answer in time and the template cache to be loaded, and it stops
+// being true as soon as a graceful shutdown starts. the response breaks the result down by
+// check, and the status code is 503 when we aren't ready
+func (app *application) readyz(w http.ResponseWriter, r *http.Request) {
+	checks := map[string]checkResult{
+		"database": app.runCheck(r, "database", func(ctx context.Context) error {
+			return app.db.PingContext(ctx)
+		}),
+		"sessions": app.runCheck(r, "sessions", func(ctx context.Context) error {
+			_, _, err := app.sessionManager.Store.Find(readinessSessionToken)
+			return err
+		}),
+	}
+
+	templates := checkResult{Status: "ok", Count: len(app.templateCache)}
+	if templates.Count == 0 {
+		templates.Status = "error"
+	}
+	checks["templates"] = templates
+
+	status, code := "ready", http.StatusOK
+	for _, c := range checks {
+		if c.Status != "ok" {
+			status, code = "not ready", http.StatusServiceUnavailable
+		}
+	}
+	if app.draining.Load() {
+		status, code = "draining", http.StatusServiceUnavailable
+	}
+
+	app.writeJSON(w, r, code, envelope{"status": status, "checks": checks}, nil)
+}
+
+// run a single readiness check with readinessTimeout to finish. fn is run in its own goroutine,
+// because not everything we check takes a context, and if it runs out of time it is left to
+// finish in the background. until it has, the check isn't started again and counts as timed
+// out, so that a stuck dependency doesn't pile up a goroutine for every request. failures are
+// logged, but the errors aren't in the response, since they can give away details of our
+// network
+func (app *application) runCheck(r *http.Request, name string, fn func(ctx context.Context) error) checkResult {
+	if _, running := app.checksRunning.LoadOrStore(name, true); running {
+		app.requestLogger(r).Warn("readiness check failed", "check", name, "error", "still running from an earlier request")
+		return checkResult{Status: "timeout"}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() {
+		defer app.checksRunning.Delete(name)
+		done <- fn(ctx)
+	}()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	result := checkResult{Status: "ok", Duration: time.Since(start).Round(time.Microsecond).String()}
+
+	if err != nil {
+		result.Status = "error"
+		if errors.Is(err, context.DeadlineExceeded) {
+			result.Status = "timeout"
+		}
+		app.requestLogger(r).Warn("readiness check failed", "check", name, "error", err)
+	}
+	return result
+}
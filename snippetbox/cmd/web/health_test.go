@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+// a session store which fails every lookup, as if its database had gone away
+type brokenStore struct{}
+
+func (brokenStore) Find(token string) ([]byte, bool, error) {
+	return nil, false, errors.New("session store down")
+}
+
+func (brokenStore) Commit(token string, b []byte, expiry time.Time) error {
+	return errors.New("session store down")
+}
+
+func (brokenStore) Delete(token string) error {
+	return errors.New("session store down")
+}
+
+func TestHealthz(t *testing.T) {
+	app := newTestApplication(t)
+
+	// liveness doesn't depend on the database
+	app.db = &stubDB{err: errors.New("connection refused")}
+	app.draining.Store(true)
+
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, headers, body := ts.get(t, "/healthz")
+
+	assert.Equal(t, code, http.StatusOK)
+	assert.Equal(t, headers.Get("Content-Type"), "application/json")
+	assert.Equal(t, decodeHealth(t, body).Status, "ok")
+}
+
+func TestReadyz(t *testing.T) {
+	tests := []struct {
+		name       string
+		setup      func(app *application)
+		wantCode   int
+		wantStatus string
+		wantChecks map[string]string
+	}{
+		{
+			name:       "Ready",
+			setup:      func(app *application) {},
+			wantCode:   http.StatusOK,
+			wantStatus: "ready",
+			wantChecks: map[string]string{"database": "ok", "sessions": "ok", "templates": "ok"},
+		},
+		{
+			name: "Database down",
+			setup: func(app *application) {
+				app.db = &stubDB{err: errors.New("connection refused")}
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not ready",
+			wantChecks: map[string]string{"database": "error", "sessions": "ok", "templates": "ok"},
+		},
+		{
+			name: "Session store down",
+			setup: func(app *application) {
+				app.sessionManager.Store = brokenStore{}
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not ready",
+			wantChecks: map[string]string{"database": "ok", "sessions": "error", "templates": "ok"},
+		},
+		{
+			name: "No templates",
+			setup: func(app *application) {
+				app.templateCache = map[string]*template.Template{}
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "not ready",
+			wantChecks: map[string]string{"database": "ok", "sessions": "ok", "templates": "error"},
+		},
+		{
+			name: "Draining",
+			setup: func(app *application) {
+				app.draining.Store(true)
+			},
+			wantCode:   http.StatusServiceUnavailable,
+			wantStatus: "draining",
+			wantChecks: map[string]string{"database": "ok", "sessions": "ok", "templates": "ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			tt.setup(app)
+
+			ts := newTestServer(t, app.adminRoutes())
+			defer ts.Close()
+
+			code, _, body := ts.get(t, "/readyz")
+			assert.Equal(t, code, tt.wantCode)
+
+			resp := decodeHealth(t, body)
+
+			assert.Equal(t, resp.Status, tt.wantStatus)
+			assert.Equal(t, len(resp.Checks), len(tt.wantChecks))
+			for name, want := range tt.wantChecks {
+				assert.Equal(t, resp.Checks[name].Status, want)
+			}
+		})
+	}
+}
+
+func TestReadyzAdminListener(t *testing.T) {
+	app := newTestApplication(t)
+	app.draining.Store(true)
+
+	// the admin listener answers too, which is how the draining state can be seen once the
+	// main listener has stopped accepting connections
+	ts := newTestServer(t, app.adminRoutes())
+	defer ts.Close()
+
+	code, _, body := ts.get(t, "/readyz")
+
+	assert.Equal(t, code, http.StatusServiceUnavailable)
+	resp := decodeHealth(t, body)
+	assert.Equal(t, resp.Status, "draining")
+	assert.Equal(t, resp.Checks["templates"].Count, len(app.templateCache))
+}
+
+func TestReadyzNotPublic(t *testing.T) {
+	app := newTestApplication(t)
+
+	// anybody can reach the main listener, and shouldn't be able to make us query the
+	// database with every request
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, _, _ := ts.get(t, "/readyz")
+	assert.Equal(t, code, http.StatusNotFound)
+}
+
+func TestRunCheckTimeout(t *testing.T) {
+	app := newTestApplication(t)
+
+	// a check which is stuck until we release it, and doesn't look at its context, like the
+	// session store lookup
+	release := make(chan struct{})
+	var calls atomic.Int32
+	check := func(ctx context.Context) error {
+		calls.Add(1)
+		<-release
+		return nil
+	}
+
+	// the request's own deadline is shorter than readinessTimeout, and wins
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r := httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(ctx)
+
+	result := app.runCheck(r, "stuck", check)
+	assert.Equal(t, result.Status, "timeout")
+	assert.Equal(t, result.Duration != "", true)
+
+	// while the first check is still stuck, asking again doesn't start another one
+	r = httptest.NewRequest(http.MethodGet, "/readyz", nil)
+	result = app.runCheck(r, "stuck", check)
+	assert.Equal(t, result.Status, "timeout")
+	assert.Equal(t, calls.Load(), int32(1))
+
+	// once it has finished, the check runs again
+	close(release)
+	deadline := time.Now().Add(5 * time.Second)
+	for app.runCheck(r, "stuck", check).Status != "ok" {
+		if time.Now().After(deadline) {
+			t.Fatal("check did not run again")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	assert.Equal(t, calls.Load(), int32(2))
+}
+
+// the body of a /healthz or /readyz response
+type healthResponse struct {
+	Status string
+	Checks map[string]checkResult
+}
+
+func decodeHealth(t *testing.T, body string) healthResponse {
+	var resp healthResponse
+	err := json.Unmarshal([]byte(body), &resp)
+	assert.NilError(t, err)
+	return resp
+}
@@ -4,24 +4,41 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"net"
 	"net/http"
+	"net/url"
 	"runtime/debug"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-playground/form/v4"
+	"github.com/julienschmidt/httprouter"
 	"github.com/justinas/nosurf"
+	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/internal/throttle"
+	"snippetbox.lets-go/internal/totp"
 )
 
-// this serverError helper writes an error message and stack trace to errorLog
+// this serverError helper logs the error and a stack trace, along with the request id,
 // then sends a generic 500 Internal Server Error response to user.
-func (app *application) serverError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack()) // debug.Stack() gets stack trace for current goroutines
-	app.errorLog.Output(2, trace)                              // change depth of stack trace
+func (app *application) serverError(w http.ResponseWriter, r *http.Request, err error) {
+	app.logServerError(r, err)
 
 	// write internal server error to response writer
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
+// log an error which stopped us handling r, with the stack trace of the current goroutine
+// so that we can see where it came from
+func (app *application) logServerError(r *http.Request, err error) {
+	app.requestLogger(r).Error(err.Error(),
+		"method", r.Method,
+		"uri", logURI(r.URL),
+		"trace", string(debug.Stack()),
+	)
+}
+
 // this helper sends specific status codes to the user as well as the status text
 func (app *application) clientError(w http.ResponseWriter, status int) {
 	http.Error(w, http.StatusText(status), status)
@@ -32,14 +49,14 @@ func (app *application) notFound(w http.ResponseWriter) {
 	app.clientError(w, http.StatusNotFound)
 }
 
-func (app *application) render(w http.ResponseWriter, status int, page string, data *templateData) {
+func (app *application) render(w http.ResponseWriter, r *http.Request, status int, page string, data *templateData) {
 
 	// retrieve the appropriate template set from our app cache. if no such entry exists, then create a new error
 	// and call the serverError() helper
 	ts, ok := app.templateCache[page]
 	if !ok {
 		err := fmt.Errorf("the template %s does not exist", page)
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -47,10 +64,12 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 	buf := new(bytes.Buffer)
 
 	// write template to the trial buffer to test that our template write works, instead of straight to the writer. if there is an error
-	// call our serverError() helper
+	// call our serverError() helper. how long it takes goes in the metrics
+	start := time.Now()
 	err := ts.ExecuteTemplate(buf, "base", data)
+	app.metrics.renderDuration.WithLabelValues(page).Observe(time.Since(start).Seconds())
 	if err != nil {
-		app.serverError(w, err)
+		app.serverError(w, r, err)
 		return
 	}
 
@@ -62,14 +81,18 @@ func (app *application) render(w http.ResponseWriter, status int, page string, d
 }
 
 func (app *application) newTemplateData(r *http.Request) *templateData {
+	now := app.now()
 	return &templateData{
-		CurrentYear: time.Now().Year(),
+		Now:         now,
+		CurrentYear: now.Year(),
 		// add flash message to template data if one exists
 		// this will be triggered to user when they create a snippet. otherwise it will be an empty string and will
 		// not be rendered in the template display
-		Flash:           app.sessionManager.PopString(r.Context(), "flash"),
-		IsAuthenticated: app.isAuthenticated(r),
-		CSRFToken:       nosurf.Token(r),
+		Flash:               app.sessionManager.PopString(r.Context(), "flash"),
+		IsAuthenticated:     app.isAuthenticated(r),
+		AuthenticatedUserID: app.authenticatedUserID(r),
+		AuthenticatedUser:   app.authenticatedUser(r),
+		CSRFToken:           nosurf.Token(r),
 	}
 }
 
@@ -98,11 +121,318 @@ func (app *application) decodePostForm(r *http.Request, dst any) error {
 	return nil
 }
 
+// return the user who is logged in for the current request, or nil if the request is not
+// from an authenticated user
+func (app *application) authenticatedUser(r *http.Request) *models.User {
+	user, _ := r.Context().Value(authenticatedUserContextKey).(*models.User)
+	return user
+}
+
 // return true if the current request is from authenticated user, otherwise return false
 func (app *application) isAuthenticated(r *http.Request) bool {
-	isAuthenticated, ok := r.Context().Value(isAuthenticatedContextKey).(bool)
+	return app.authenticatedUser(r) != nil
+}
+
+// return the id of the user who is logged in for the current request, or zero if the
+// request is not from an authenticated user
+func (app *application) authenticatedUserID(r *http.Request) int {
+	user := app.authenticatedUser(r)
+	if user == nil {
+		return 0
+	}
+	return user.ID
+}
+
+// this helper fetches the snippet named by the ":id" route param and checks that it belongs
+// to the authenticated user. if it doesn't exist we send a 404, and if it belongs to somebody
+// else we send a 403, unless the user has overrideRole. an empty overrideRole means only the
+// owner will do. the bool return value reports whether the handler should carry on.
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request, overrideRole string) (*models.Snippet, bool) {
+	params := httprouter.ParamsFromContext(r.Context())
+
+	id, err := strconv.Atoi(params.ByName("id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return nil, false
+	}
+
+	snippet, err := app.snippets.Get(id)
+	if err != nil {
+		if errors.Is(err, models.ErrNoRecord) {
+			app.notFound(w)
+		} else {
+			app.serverError(w, r, err)
+		}
+		return nil, false
+	}
+
+	user := app.authenticatedUser(r)
+	if snippet.UserID != user.ID && (overrideRole == "" || !user.HasRole(overrideRole)) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+	return snippet, true
+}
+
+// what activation tokens are signed for, so that they can't be used as any other kind of token
+const activationPurpose = "activation"
+
+// how long a user has to wait before asking for another activation email
+const activationResendInterval = 5 * time.Minute
+
+// sign an activation token for the user and email them a link to activate their account. the
+// token carries their email address as well as their id, so that it only works for the address
+// it was sent to
+func (app *application) sendActivationEmail(id int, name, email string) {
+	token := app.signer.Sign(activationPurpose, fmt.Sprintf("%d:%s", id, email), app.activationTTL)
+
+	app.sendEmail(email, "user_activation.tmpl", map[string]any{
+		"Name":          name,
+		"ActivationURL": app.baseURL + "/user/activate?token=" + url.QueryEscape(token),
+		"ExpiresIn":     humanDuration(app.activationTTL),
+	})
+}
+
+// check an activation token and return the user id and email address it was issued for.
+// ok is false if the token has been tampered with or has expired
+func (app *application) verifyActivationToken(token string) (id int, email string, ok bool) {
+	value, err := app.signer.Verify(activationPurpose, token)
+	if err != nil {
+		return 0, "", false
+	}
+
+	idStr, email, ok := strings.Cut(value, ":")
+	id, err = strconv.Atoi(idStr)
+	if !ok || err != nil {
+		return 0, "", false
+	}
+	return id, email, true
+}
+
+// the name authenticator apps show next to a user's codes
+const totpIssuer = "Snippetbox"
+
+// how long a user has after entering their password to enter their two-factor code, and how
+// many wrong codes they can enter before they have to start again
+const (
+	twoFactorLoginTimeout = 5 * time.Minute
+	maxTwoFactorAttempts  = 5
+)
+
+// return the id of the user who has got past the password step of logging in but still needs
+// to enter a two-factor code, or zero if there isn't one or they took too long
+func (app *application) pendingTwoFactorUserID(r *http.Request) int {
+	id := app.sessionManager.GetInt(r.Context(), "twoFactorUserID")
+	if id == 0 {
+		return 0
+	}
+	if app.now().Unix() >= app.sessionManager.GetInt64(r.Context(), "twoFactorExpires") {
+		app.clearPendingTwoFactor(r)
+		return 0
+	}
+	return id
+}
+
+// forget about a half-finished two-factor login
+func (app *application) clearPendingTwoFactor(r *http.Request) {
+	app.sessionManager.Remove(r.Context(), "twoFactorUserID")
+	app.sessionManager.Remove(r.Context(), "twoFactorEmail")
+	app.sessionManager.Remove(r.Context(), "twoFactorExpires")
+	app.sessionManager.Remove(r.Context(), "twoFactorAttempts")
+}
+
+// check a code from the second step of logging in. it can be a TOTP code from the user's app,
+// which is only accepted once, or failing that one of their recovery codes, which is used up.
+// usedRecoveryCode reports which kind of code it was
+func (app *application) checkTwoFactorCode(userID int, code string) (usedRecoveryCode, ok bool, err error) {
+	secret, err := app.twoFactor.Secret(userID)
+	if err != nil || secret == "" {
+		return false, false, err
+	}
+
+	if step, valid := totp.Validate(secret, code, app.now()); valid {
+		ok, err := app.twoFactor.UseStep(userID, step)
+		return false, ok, err
+	}
+
+	ok, err = app.twoFactor.UseRecoveryCode(userID, strings.TrimSpace(code))
+	return ok, ok, err
+}
+
+// set up the limiters for logins, which count attempts in store. an email gets a few free tries
+// before it has to wait, with the wait doubling each time, and is locked out after
+// lockoutAttempts attempts in a row without getting the password right. an IP address gets
+// more room, because many people can share one behind a NAT, but it still slows down anybody
+// trying passwords against lots of emails
+func (app *application) setLoginThrottle(store models.ThrottleModelInterface, lockoutAttempts int, lockout time.Duration) {
+	// read the clock through app, so that tests which swap it are seen by the limiters
+	now := func() time.Time { return app.now() }
+
+	app.emailThrottle = throttle.New(store, throttle.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: lockoutAttempts,
+		Lockout:         lockout,
+	}, now)
+	app.ipThrottle = throttle.New(store, throttle.Policy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Second,
+		MaxDelay:        time.Minute,
+		LockoutAttempts: 5 * lockoutAttempts,
+		Lockout:         lockout,
+	}, now)
+}
+
+// set up the limiters for password reset emails, which count requests in store. anybody can
+// ask for a reset link to be sent to any address, so without a limit the form could be used to
+// flood somebody's inbox. an email gets a few links before it has to wait, and an IP address
+// gets more, because many people can share one behind a NAT
+func (app *application) setResetThrottle(store models.ThrottleModelInterface) {
+	now := func() time.Time { return app.now() }
+
+	app.resetEmailThrottle = throttle.New(store, throttle.Policy{
+		FreeAttempts:    3,
+		BaseDelay:       5 * time.Minute,
+		MaxDelay:        time.Hour,
+		LockoutAttempts: 10,
+		Lockout:         24 * time.Hour,
+	}, now)
+	app.resetIPThrottle = throttle.New(store, throttle.Policy{
+		FreeAttempts:    10,
+		BaseDelay:       time.Minute,
+		MaxDelay:        time.Hour,
+		LockoutAttempts: 50,
+		Lockout:         24 * time.Hour,
+	}, now)
+}
+
+// the longest email address the login and forgot password forms accept. its throttle key has
+// to fit in the 255 characters of the name column in the throttle table, after a prefix like
+// "login:email:"
+const maxThrottledEmailLength = 255 - len("login:email:")
+
+// return the throttle keys for the IP address of the client making r and for email, for the
+// action called name, like "login"
+func throttleKeys(r *http.Request, name, email string) (ipKey, emailKey string) {
+	return name + ":ip:" + clientIP(r), name + ":email:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+// count an attempt against ipKey and emailKey, and return how long the client has to wait if it
+// can't go ahead, or zero if it can. the attempt is refused if either key has to wait. the
+// attempt isn't counted against the email when the IP address has to wait, but the client is
+// still told the longer of the two waits
+func throttleAttempt(ipThrottle, emailThrottle *throttle.Limiter, ipKey, emailKey string) (time.Duration, error) {
+	ipWait, err := ipThrottle.Attempt(ipKey)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait == 0 {
+		return emailThrottle.Attempt(emailKey)
+	}
+
+	emailWait, err := emailThrottle.RetryAfter(emailKey)
+	if err != nil {
+		return 0, err
+	}
+	if ipWait > emailWait {
+		return ipWait, nil
+	}
+	return emailWait, nil
+}
+
+// count a login attempt by the client making r as email, before its password is checked, and
+// return how long it has to wait if it can't go ahead, or zero if it can. counting before the
+// password check, rather than after a failure, means that requests sent in parallel can't all
+// slip past the throttle while their checks are running
+func (app *application) loginAttempt(r *http.Request, email string) (time.Duration, error) {
+	ipKey, emailKey := throttleKeys(r, "login", email)
+	return throttleAttempt(app.ipThrottle, app.emailThrottle, ipKey, emailKey)
+}
+
+// count a request by the client making r for a password reset email to email, and return how
+// long it has to wait if it can't have one, or zero if it can
+func (app *application) resetAttempt(r *http.Request, email string) (time.Duration, error) {
+	ipKey, emailKey := throttleKeys(r, "reset", email)
+	return throttleAttempt(app.resetIPThrottle, app.resetEmailThrottle, ipKey, emailKey)
+}
+
+// set the Retry-After header for a client which has to wait. it is in whole seconds, so we
+// round up rather than tell the client to come back early
+func setRetryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int((wait+time.Second-1)/time.Second)))
+}
+
+// forget the login attempts for email once somebody has got its password right, and take back
+// the attempt counted against the IP address, so that lots of people logging in from behind one
+// NAT don't slow each other down. the earlier attempts for the IP address are left alone, so
+// that guessing one password doesn't reset the count for somebody working through a list of
+// emails
+func (app *application) loginSucceeded(r *http.Request, email string) error {
+	ipKey, emailKey := throttleKeys(r, "login", email)
+
+	if err := app.ipThrottle.Undo(ipKey); err != nil {
+		return err
+	}
+	return app.emailThrottle.Reset(emailKey)
+}
+
+// take back the attempt counted against the IP address when a user who has two-factor
+// authentication gets their password right. the attempts for the email are left until they have
+// entered their code too, so that knowing the password doesn't give unlimited guesses at it
+func (app *application) loginPasswordAccepted(r *http.Request, email string) error {
+	ipKey, _ := throttleKeys(r, "login", email)
+	return app.ipThrottle.Undo(ipKey)
+}
+
+// return the IP address of the client making r, without the port. behind a proxy this is only
+// the client's address if the proxy is in app.trustedProxies, so that realIP() has replaced
+// the proxy's own address
+func clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return ip
+}
+
+// how often we record that a logged in session is still being used. doing it on every request
+// would mean a write to the database for every page
+const sessionTouchInterval = time.Minute
+
+// log the user in on the current session, and record the session against them so that it
+// shows up in their list of sessions. it must be called after RenewToken(), so that we
+// record the new session token
+func (app *application) startUserSession(r *http.Request, userID int) error {
+	token := app.sessionManager.Token(r.Context())
+	err := app.userSessions.Insert(userID, token, r.UserAgent(), clientIP(r), app.sessionManager.Lifetime)
+	if err != nil {
+		return err
+	}
+
+	app.sessionManager.Put(r.Context(), "authenticatedUserID", userID)
+	return nil
+}
+
+// return the id of the logged in session making r, or zero if it isn't logged in
+func (app *application) userSessionID(r *http.Request) int {
+	id, ok := r.Context().Value(userSessionIDContextKey).(int)
 	if !ok {
-		return false
+		return 0
+	}
+	return id
+}
+
+// read the ?page= query string value of a listing split into numbered pages, defaulting to the
+// first page. anything other than a positive number is an error
+func pageNumber(r *http.Request) (int, error) {
+	p := r.URL.Query().Get("page")
+	if p == "" {
+		return 1, nil
+	}
+	page, err := strconv.Atoi(p)
+	if err != nil || page < 1 {
+		return 0, errors.New("invalid page number")
 	}
-	return isAuthenticated
+	return page, nil
 }
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// the header which carries the request id, in both directions. a load balancer or another
+// service in front of us can set it so that their logs and ours can be matched up
+const requestIDHeader = "X-Request-ID"
+
+// the longest request id we accept from a client. anything longer gets a new id instead
+const maxRequestIDLength = 128
+
+// query string parameters which are never logged, because they are secrets. password reset and
+// account activation links carry a token which is as good as the password until it is used
+var redactedParams = []string{"token"}
+
+// return the path and query of u for the logs, with the values of redactedParams hidden
+func logURI(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range redactedParams {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.RequestURI()
+	}
+
+	clean := *u
+	clean.RawQuery = query.Encode()
+	return clean.RequestURI()
+}
+
+// create the structured logger for the app, writing to w in format ("text" or "json") and
+// dropping anything below level ("debug", "info", "warn" or "error"). both are checked by
+// config.Validate(), so anything unexpected falls back to text at info
+func newLogger(w io.Writer, format, level string) *slog.Logger {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		lvl = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: lvl}
+
+	if format == "json" {
+		return slog.New(slog.NewJSONHandler(w, opts))
+	}
+	return slog.New(slog.NewTextHandler(w, opts))
+}
+
+// middleware which gives every request an id. the id from an X-Request-ID header is kept if
+// it looks sensible, and otherwise we make a random one. it goes in the request context for
+// requestLogger() and is sent back in the X-Request-ID response header
+func requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// report whether id is safe to put in our logs and headers: not empty, not too long, and
+// only made of letters, digits and a few punctuation characters
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '_', c == '.', c == ':':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// make a random request id of 32 hex characters
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		// crypto/rand doesn't fail on any platform we run on, but an id isn't worth a 500
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// return the app's logger with the request's id added to every line
+func (app *application) requestLogger(r *http.Request) *slog.Logger {
+	id, ok := r.Context().Value(requestIDContextKey).(string)
+	if !ok {
+		return app.logger
+	}
+	return app.logger.With("request_id", id)
+}
+
+// wraps a http.ResponseWriter to remember the status code and count the bytes written, so
+// that logRequest can log them once the handler has finished
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *responseRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *responseRecorder) Write(b []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(b)
+	rec.bytes += n
+	return n, err
+}
+
+// let http.ResponseController reach the underlying ResponseWriter
+func (rec *responseRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
+// middleware which logs every request once it has been handled, with the response status,
+// the number of bytes in the body and how long it took
+func (app *application) logRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		next.ServeHTTP(rec, r)
+
+		// a handler which never writes anything sends a 200 with an empty body
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+
+		app.requestLogger(r).Info("request",
+			"remote_addr", r.RemoteAddr,
+			"proto", r.Proto,
+			"method", r.Method,
+			"uri", logURI(r.URL),
+			"status", rec.status,
+			"bytes", rec.bytes,
+			"duration", time.Since(start),
+		)
+	})
+}
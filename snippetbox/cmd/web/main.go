@@ -1,79 +1,246 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
 	"crypto/tls"
 	"database/sql"
+	"errors"
 	"flag"
+	"fmt"
 	"html/template"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/alexedwards/scs/mysqlstore"
+	"github.com/alexedwards/scs/postgresstore"
+	"github.com/alexedwards/scs/sqlite3store"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-playground/form/v4"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"snippetbox.lets-go/internal/config"
+	"snippetbox.lets-go/internal/dialect"
+	"snippetbox.lets-go/internal/mailer"
+	"snippetbox.lets-go/internal/migrate"
 	"snippetbox.lets-go/internal/models"
-
-	_ "github.com/go-sql-driver/mysql"
+	"snippetbox.lets-go/internal/signer"
+	"snippetbox.lets-go/internal/throttle"
+	"snippetbox.lets-go/migrations"
+	"snippetbox.lets-go/ui"
 )
 
 // struct to hold application-wide dependencies
 type application struct {
-	errorLog       *log.Logger
-	infoLog        *log.Logger
-	snippets       *models.SnippetModel
-	users          *models.UserModel
+	logger         *slog.Logger
+	metrics        *metrics
+	db             pinger // only for the readiness check. the models hold their own connection
+	snippets       models.SnippetModelInterface
+	users          models.UserModelInterface
+	tokens         models.TokenModelInterface
+	passwordResets models.PasswordResetModelInterface
 	templateCache  map[string]*template.Template
 	formDecoder    *form.Decoder
 	sessionManager *scs.SessionManager
+	pageSize       int // number of snippets shown per page on the home page
+	mailer         *mailer.Mailer
+	baseURL        string        // public URL of the app, for links in emails
+	resetTTL       time.Duration // how long a password reset link lasts
+	signer         *signer.Signer
+	activationTTL  time.Duration // how long an account activation link lasts
+	twoFactor      models.TwoFactorModelInterface
+	userSessions   models.UserSessionModelInterface
+	admin          models.AdminModelInterface
+
+	// failed logins are throttled per IP address and per email. see setLoginThrottle()
+	ipThrottle    *throttle.Limiter
+	emailThrottle *throttle.Limiter
+
+	// and so are password reset emails. see setResetThrottle()
+	resetIPThrottle    *throttle.Limiter
+	resetEmailThrottle *throttle.Limiter
+
+	// requests from these addresses take the client's address from X-Forwarded-For. see realIP()
+	trustedProxies []netip.Prefix
+
+	// the clock, for checking TOTP codes and login timeouts. tests replace it with a fake one
+	now func() time.Time
+
+	// set once a graceful shutdown has started, which makes /readyz fail
+	draining atomic.Bool
+
+	// the names of the readiness checks which are still running. see runCheck()
+	checksRunning sync.Map
+
+	// background goroutines started with app.background() are tracked by wg, and
+	// backgroundCtx is cancelled by stopBackground() when the server shuts down
+	wg             sync.WaitGroup
+	backgroundCtx  context.Context
+	stopBackground context.CancelFunc
 }
 
 func main() {
 
-	// define cmd line args
-	addr := flag.String("addr", ":4000", "HTTP Network address")
-	dsn := flag.String("dsn", "", "MySql Data Source Name. should be in the form web:pass@/snippetbox?parseTime=true")
+	// load the config from the defaults, config file, environment and command line flags,
+	// in that order. Load() also validates the result, so we can't start with a bad config
+	cfg, err := config.Load(os.Args[0], os.Args[1:], os.Stderr)
+	if err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 
-	// parses the command line args from the user
-	// if we do not call this, it will only use the default argument set by the flag variables
-	flag.Parse()
+	// with -print-config we show the effective config, with secrets redacted, and stop there
+	if cfg.PrintConfig {
+		cfg.Print(os.Stdout)
+		return
+	}
+
+	// create the structured logger which everything logs through
+	logger := newLogger(os.Stdout, cfg.LogFormat, cfg.LogLevel)
 
-	// create loggers for appropriate messages
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	errorLog := log.New(os.Stderr, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+	// log an error and stop. this is only for problems during startup, while there isn't
+	// anything to shut down gracefully
+	fatal := func(err error) {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
 
-	// connect to DB
-	db, err := openDB(*dsn)
+	// look up the SQL dialect for the chosen database, and connect to DB
+	d, err := dialect.Get(cfg.DBDriver)
+	if err != nil {
+		fatal(err)
+	}
+	db, err := dialect.Open(d, cfg.DSN)
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(err)
 	}
 	defer db.Close()
 
+	// apply pending schema migrations if asked to. the migrator holds a database lock while it
+	// runs, so it's safe for several instances to start at the same time
+	if cfg.Migrate {
+		files, err := migrations.For(d)
+		if err != nil {
+			fatal(err)
+		}
+		m, err := migrate.New(db, d, files)
+		if err != nil {
+			fatal(err)
+		}
+		n, err := m.Up()
+		if err != nil {
+			fatal(err)
+		}
+		logger.Info("applied migrations", "count", n)
+	}
+
 	// init new template cache
 	templateCache, err := newTemplateCache()
 	if err != nil {
-		errorLog.Fatal(err)
+		fatal(err)
 	}
 
 	// init form decoder
 	formDecoder := form.NewDecoder()
 
 	// use the scs.New() func to init a new session manager
-	// then we configure it to use our MySql database as the session store, and set a lifetime of 12 hours
+	// then we configure it to use our database as the session store, and set the configured lifetime (12 hours by default)
 	sessionManager := scs.New()
-	sessionManager.Store = mysqlstore.New(db)
-	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Store = newSessionStore(d, db)
+	sessionManager.Lifetime = cfg.SessionLifetime
+
+	// emails go through an SMTP server when one is configured, and otherwise they are written
+	// to files in the outbox directory so that we can read them in development
+	var transport mailer.Transport = &mailer.FileOutbox{Dir: cfg.MailOutbox}
+	if cfg.SMTPHost != "" {
+		transport = &mailer.SMTPTransport{
+			Host:     cfg.SMTPHost,
+			Port:     cfg.SMTPPort,
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+		}
+	}
+	emailTemplates, err := fs.Sub(ui.Files, "email")
+	if err != nil {
+		fatal(err)
+	}
+
+	// activation links are signed with the configured secret key. without one we make up a
+	// key, which is fine for development but means links in emails die with the process
+	secretKey := []byte(cfg.SecretKey)
+	if len(secretKey) == 0 {
+		logger.Warn("no secret-key set, so using a random one. activation links will stop working on restart")
+		secretKey = make([]byte, signer.MinKeyLength)
+		if _, err := rand.Read(secretKey); err != nil {
+			fatal(err)
+		}
+	}
+	sgnr, err := signer.New(secretKey)
+	if err != nil {
+		fatal(err)
+	}
 
 	// app dependency struct
 	app := &application{
-		errorLog:       errorLog,
-		infoLog:        infoLog,
-		snippets:       &models.SnippetModel{DB: db},
-		users:          &models.UserModel{DB: db},
+		logger:         logger,
+		metrics:        newMetrics(db),
+		db:             db,
+		snippets:       &models.SnippetModel{DB: db, Dialect: d},
+		users:          &models.UserModel{DB: db, Dialect: d, BcryptCost: cfg.BcryptCost},
+		tokens:         &models.TokenModel{DB: db, Dialect: d},
+		passwordResets: &models.PasswordResetModel{DB: db, Dialect: d},
+		twoFactor:      &models.TwoFactorModel{DB: db, Dialect: d},
+		userSessions:   &models.UserSessionModel{DB: db, Dialect: d},
+		admin:          &models.AdminModel{DB: db, Dialect: d},
 		templateCache:  templateCache,
 		formDecoder:    formDecoder,
 		sessionManager: sessionManager,
+		pageSize:       cfg.PageSize,
+		mailer:         mailer.New(transport, cfg.MailSender, emailTemplates),
+		baseURL:        strings.TrimSuffix(cfg.BaseURL, "/"),
+		resetTTL:       cfg.PasswordResetTTL,
+		signer:         sgnr,
+		activationTTL:  cfg.ActivationTTL,
+		now:            time.Now,
+	}
+	app.backgroundCtx, app.stopBackground = context.WithCancel(context.Background())
+
+	// Load() has already checked that these parse
+	app.trustedProxies, _ = cfg.Proxies()
+
+	// failed logins and password reset emails are counted in memory unless we've been asked
+	// to keep them in the database, which lets several instances behind a load balancer share
+	// the counts
+	var throttleStore models.ThrottleModelInterface = throttle.NewMemoryStore()
+	if cfg.LoginThrottleStore == "sql" {
+		throttleStore = &models.ThrottleModel{DB: db, Dialect: d}
+	}
+	app.setLoginThrottle(throttleStore, cfg.LoginLockoutAttempts, cfg.LoginLockout)
+	app.setResetThrottle(throttleStore)
+
+	// start the background reaper which deletes expired snippets from the database
+	if cfg.ReapInterval > 0 {
+		rp := &reaper{
+			snippets:     app.snippets,
+			sessions:     &models.SessionModel{DB: db, Dialect: d},
+			throttle:     throttleStore,
+			userSessions: app.userSessions,
+			now:          app.now,
+			logger:       logger.With("component", "reaper"),
+			interval:     cfg.ReapInterval,
+			batchSize:    cfg.ReapBatch,
+		}
+		app.background(rp.Run)
 	}
 
 	// initialize a tls.Config struct to hold non-default TLS settings we want our server to use.
@@ -83,32 +250,87 @@ func main() {
 		CurvePreferences: []tls.CurveID{tls.X25519, tls.CurveP256},
 	}
 
-	// initialize our own http.Server struct, so it can use our own pre-defined loggers (above)
+	// in ACME mode the certificates come from the ACME server rather than the files. the
+	// acme-tls/1 protocol lets it check that we own the domain over the HTTPS listener
+	var certManager *autocert.Manager
+	if domains := cfg.Domains(); len(domains) > 0 {
+		certManager, err = newACMEManager(cfg)
+		if err != nil {
+			fatal(err)
+		}
+		tlsConfig.GetCertificate = app.acmeCertificate(certManager)
+		tlsConfig.NextProtos = []string{"h2", "http/1.1", acme.ALPNProto}
+		logger.Info("getting certificates with ACME", "domains", strings.Join(domains, ","), "directory", cfg.ACMEDirectory)
+	} else {
+		// otherwise they come from the files, and are reloaded when the files change or we
+		// receive SIGHUP, so that a new certificate doesn't need a restart
+		certs, err := newCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile, logger.With("component", "tls"))
+		if err != nil {
+			fatal(err)
+		}
+		tlsConfig.GetCertificate = certs.GetCertificate
+		app.background(func(ctx context.Context) {
+			certs.Watch(ctx, cfg.TLSWatch)
+		})
+	}
+
+	// initialize our own http.Server struct, so that the errors it logs itself go through our
+	// logger too
 	srv := &http.Server{
-		Addr:         *addr,
-		ErrorLog:     errorLog,
+		Addr:         cfg.Addr,
+		ErrorLog:     slog.NewLogLogger(logger.Handler(), slog.LevelError),
 		Handler:      app.routes(),
 		TLSConfig:    tlsConfig,
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  cfg.IdleTimeout,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
 	}
 
-	// listen on a port and start the server
-	// two parameters are passed in, the TCP network address (port :4000) and the servemux
-	infoLog.Printf("starting server on %s\n", *addr)
-	err = srv.ListenAndServeTLS("./tls/cert.pem", "./tls/key.pem")
-	errorLog.Fatal(err)
-}
+	// the plain HTTP listener redirects to HTTPS, and answers ACME challenges in ACME mode
+	var plain *http.Server
+	if cfg.HTTPAddr != "" {
+		plain = &http.Server{
+			Addr:         cfg.HTTPAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      app.httpRoutes(certManager, cfg.Addr),
+			IdleTimeout:  cfg.IdleTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}
+	}
 
-// function to open mysql db and return pointer to db handle
-func openDB(dsn string) (*sql.DB, error) {
-	db, err := sql.Open("mysql", dsn)
+	// the admin listener serves the metrics and health checks over plain HTTP. it is meant to
+	// be reachable only from inside the network, so it isn't on the public address
+	var admin *http.Server
+	if cfg.MetricsAddr != "" {
+		admin = &http.Server{
+			Addr:         cfg.MetricsAddr,
+			ErrorLog:     srv.ErrorLog,
+			Handler:      app.adminRoutes(),
+			IdleTimeout:  cfg.IdleTimeout,
+			ReadTimeout:  cfg.ReadTimeout,
+			WriteTimeout: cfg.WriteTimeout,
+		}
+	}
+
+	// serve until we receive a signal to shut down. if the shutdown isn't clean we exit with a
+	// non-zero status, closing the database first because os.Exit() skips deferred calls
+	err = app.serve(srv, plain, admin, cfg)
 	if err != nil {
-		return nil, err
+		logger.Error(err.Error())
+		db.Close()
+		os.Exit(1)
 	}
-	if err = db.Ping(); err != nil {
-		return nil, err
+}
+
+// return the scs session store which matches the database dialect. each store expects
+// the sessions table created by the migrations for its database
+func newSessionStore(d dialect.Dialect, db *sql.DB) scs.Store {
+	switch d {
+	case dialect.Postgres:
+		return postgresstore.New(db)
+	case dialect.SQLite:
+		return sqlite3store.New(db)
 	}
-	return db, nil
+	return mysqlstore.New(db)
 }
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/julienschmidt/httprouter"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// the route label for requests which didn't match any of our routes, like 404s
+const unmatchedRoute = "unmatched"
+
+// the Prometheus metrics for the app. they are kept in their own registry rather than the
+// global default one, so that every test application gets a fresh set
+type metrics struct {
+	registry        *prometheus.Registry
+	requests        *prometheus.CounterVec
+	requestDuration *prometheus.HistogramVec
+	renderDuration  *prometheus.HistogramVec
+	panics          prometheus.Counter
+}
+
+// create the metrics and register them, along with the standard Go runtime and process
+// metrics. when db isn't nil the connection pool statistics from db.Stats() are included too
+func newMetrics(db *sql.DB) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		requests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "snippetbox_http_requests_total",
+			Help: "HTTP requests handled, by method, route pattern and status code.",
+		}, []string{"method", "route", "status"}),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_http_request_duration_seconds",
+			Help:    "How long HTTP requests took to handle, by method and route pattern.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+		renderDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "snippetbox_template_render_duration_seconds",
+			Help:    "How long HTML pages took to render, by template.",
+			Buckets: []float64{.0005, .001, .0025, .005, .01, .025, .05, .1},
+		}, []string{"template"}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "snippetbox_http_panics_recovered_total",
+			Help: "Panics in HTTP handlers which were caught by the recoverPanic middleware.",
+		}),
+	}
+
+	m.registry.MustRegister(
+		m.requests,
+		m.requestDuration,
+		m.renderDuration,
+		m.panics,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	if db != nil {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db, "snippetbox"))
+	}
+	return m
+}
+
+// return the handler which serves the metrics in the Prometheus text format
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// holds the route pattern for a request. the instrument middleware puts an empty one in the
+// request context, and the router fills it in once it knows which route matched
+type routeLabel struct {
+	pattern string
+}
+
+// middleware which counts every request and times it, labelled by the pattern of the route
+// it matched. it has to go outside the router, so that requests which don't match any route
+// are counted too
+func (app *application) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		label := &routeLabel{pattern: unmatchedRoute}
+		rec := &responseRecorder{ResponseWriter: w}
+
+		ctx := context.WithValue(r.Context(), routeLabelContextKey, label)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		method := methodLabel(r.Method)
+		app.metrics.requests.WithLabelValues(method, label.pattern, strconv.Itoa(rec.status)).Inc()
+		app.metrics.requestDuration.WithLabelValues(method, label.pattern).Observe(time.Since(start).Seconds())
+	})
+}
+
+// the method label for a request. clients can send any method they like, so anything we
+// don't serve is lumped together to keep the number of label values down
+func methodLabel(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return method
+	}
+	return "OTHER"
+}
+
+// an httprouter.Router which records the pattern of the route that matched each request for
+// the instrument middleware. httprouter doesn't tell us which route matched, so every handler
+// is wrapped as it is registered. routes must be added with Handler() or HandlerFunc()
+type instrumentedRouter struct {
+	*httprouter.Router
+}
+
+func (ir instrumentedRouter) Handler(method, pattern string, handler http.Handler) {
+	ir.Router.Handler(method, pattern, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if label, ok := r.Context().Value(routeLabelContextKey).(*routeLabel); ok {
+			label.pattern = pattern
+		}
+		handler.ServeHTTP(w, r)
+	}))
+}
+
+func (ir instrumentedRouter) HandlerFunc(method, pattern string, handler http.HandlerFunc) {
+	ir.Handler(method, pattern, handler)
+}
+
+// the routes for the admin listener, which is kept away from the public but not from the load
+// balancer. it serves the metrics for Prometheus to scrape, and the health checks too, since
+// the admin listener is still up while the main one drains during a shutdown. /readyz is only
+// served here, because it queries the database and the session store for every request
+func (app *application) adminRoutes() http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", app.metrics.handler())
+	mux.HandleFunc("/healthz", app.healthz)
+	mux.HandleFunc("/readyz", app.readyz)
+	return mux
+}
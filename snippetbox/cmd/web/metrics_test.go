@@ -0,0 +1,68 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+// fetch the metrics from the admin routes in the Prometheus text format
+func scrapeMetrics(t *testing.T, app *application) string {
+	t.Helper()
+
+	r, err := http.NewRequest(http.MethodGet, "/metrics", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rr := httptest.NewRecorder()
+	app.adminRoutes().ServeHTTP(rr, r)
+	assert.Equal(t, rr.Code, http.StatusOK)
+	return rr.Body.String()
+}
+
+func TestMetrics(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/1")
+	ts.get(t, "/snippet/view/99")
+	ts.get(t, "/no/such/page")
+
+	// a panic in a handler is counted by recoverPanic
+	panicking := app.recoverPanic(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	}))
+	r, err := http.NewRequest(http.MethodGet, "/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	panicking.ServeHTTP(httptest.NewRecorder(), r)
+
+	body := scrapeMetrics(t, app)
+
+	// requests are labelled with the route pattern rather than the URL
+	for _, line := range []string{
+		`snippetbox_http_requests_total{method="GET",route="/snippet/view/:id",status="200"} 2`,
+		`snippetbox_http_requests_total{method="GET",route="/snippet/view/:id",status="404"} 1`,
+		`snippetbox_http_requests_total{method="GET",route="unmatched",status="404"} 1`,
+		`snippetbox_http_request_duration_seconds_count{method="GET",route="/snippet/view/:id"} 3`,
+		`snippetbox_template_render_duration_seconds_count{template="view.tmpl.html"} 2`,
+		`snippetbox_http_panics_recovered_total 1`,
+	} {
+		if !strings.Contains(body, line) {
+			t.Errorf("metrics don't contain %q", line)
+		}
+	}
+	assert.Equal(t, strings.Contains(body, "/snippet/view/1"), false)
+}
+
+func TestMethodLabel(t *testing.T) {
+	assert.Equal(t, methodLabel(http.MethodGet), "GET")
+	assert.Equal(t, methodLabel(http.MethodDelete), "DELETE")
+	assert.Equal(t, methodLabel("PROPFIND"), "OTHER")
+}
@@ -2,10 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/netip"
+	"strings"
 
 	"github.com/justinas/nosurf"
+	"snippetbox.lets-go/internal/models"
 )
 
 // middleware function to set security headers
@@ -24,13 +28,61 @@ func secureHeaders(next http.Handler) http.Handler {
 	})
 }
 
-func (app *application) logRequest(next http.Handler) http.Handler {
+// middleware which sets r.RemoteAddr to the client's address, rather than the address of the
+// proxy in front of us, when the request comes from one of app.trustedProxies. each proxy adds
+// the address it got the request from to the end of X-Forwarded-For, and anything before our
+// own proxies could have been made up by the client, so the client is the last address in the
+// header which isn't one of them
+func (app *application) realIP(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		app.infoLog.Printf("%s - %s %s %s", r.RemoteAddr, r.Proto, r.Method, r.URL.RequestURI())
+		if len(app.trustedProxies) > 0 && app.trustedProxy(clientIP(r)) {
+			if ip := app.forwardedFor(r); ip != "" {
+				r.RemoteAddr = ip
+			}
+		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// report whether ip is the address of one of our proxies
+func (app *application) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+
+	for _, p := range app.trustedProxies {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// return the client's address from the X-Forwarded-For headers of r, or "" if there isn't one
+// we can use. an address which doesn't parse stops the search, since we can't tell what was
+// added by whom beyond it
+func (app *application) forwardedFor(r *http.Request) string {
+	var hops []string
+	for _, h := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(h, ",")...)
+	}
+
+	var client string
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		client = addr.Unmap().String()
+		if !app.trustedProxy(client) {
+			break
+		}
+	}
+	return client
+}
+
 // method to handle panics and recover with proper error
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -41,12 +93,18 @@ func (app *application) recoverPanic(next http.Handler) http.Handler {
 			// use builtin recover func to cehck if there has been a panic or not
 			// if there has, set appropriate headers and send server error
 			if err := recover(); err != nil {
+				app.metrics.panics.Inc()
 
 				// set connection: close header on the response
 				w.Header().Set("Connection", "close")
 
-				// call our application's serverError() helper to return a 500 status
-				app.serverError(w, fmt.Errorf("%s", err))
+				// call our application's serverError() helper to return a 500 status. API
+				// clients get the JSON version
+				if isAPIRequest(r) {
+					app.apiServerError(w, r, fmt.Errorf("%s", err))
+				} else {
+					app.serverError(w, r, fmt.Errorf("%s", err))
+				}
 			}
 		}()
 		next.ServeHTTP(w, r)
@@ -74,6 +132,46 @@ func (app *application) requireAuthentication(next http.Handler) http.Handler {
 	})
 }
 
+// middleware which only lets through users who have verified their email address. it goes
+// after requireAuthentication in a chain. anybody else is sent to their account page, where
+// they can ask for another activation email
+func (app *application) requireActivation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.authenticatedUser(r)
+		if user == nil {
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+
+		if !user.Activated {
+			app.sessionManager.Put(r.Context(), "flash", "please activate your account with the link we emailed you before creating snippets")
+			http.Redirect(w, r, "/account/view", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// return a middleware which only lets through users who have role, or a role above it. it goes
+// after requireAuthentication in a chain, like
+//
+//	protected.Append(app.requireRole(models.RoleAdmin))
+//
+// everybody else gets a 403 Forbidden
+func (app *application) requireRole(role string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.authenticatedUser(r)
+			if user == nil || !user.HasRole(role) {
+				app.clientError(w, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 // create a middleware func which uses a customized CSRF cookie with
 // the Secure, Path and HttpOnly attributes set
 func noSurf(next http.Handler) http.Handler {
@@ -98,18 +196,46 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		// otherwise we check to see if a user with that ID exists in our database
-		exists, err := app.users.Exists(id)
-		if err != nil {
-			app.serverError(w, err)
+		// the session also has to be one of the user's logged in sessions. if it isn't, it has
+		// been revoked (or it is from before we kept track of sessions) and it is logged out
+		session, err := app.userSessions.Get(app.sessionManager.Token(r.Context()))
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+		if session == nil || session.UserID != id {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// otherwise we fetch the user with that ID from our database
+		user, err := app.users.Get(id)
+		if err != nil && !errors.Is(err, models.ErrNoRecord) {
+			app.serverError(w, r, err)
+			return
+		}
+
+		// an admin might have disabled the user after they logged in
+		if user != nil && user.Disabled {
+			app.sessionManager.Remove(r.Context(), "authenticatedUserID")
+			next.ServeHTTP(w, r)
 			return
 		}
 
 		// if a matching user is found, we know that the request is coming from an authenticated user whom
-		// exists in our database. we create a new copy of the request (with an isAuthenticatedContextKey)
-		// value of true in the request context) and assign it to r.
-		if exists {
-			ctx := context.WithValue(r.Context(), isAuthenticatedContextKey, true)
+		// exists in our database. we create a new copy of the request with the user (and so their role)
+		// in the request context and assign it to r. the id of their session goes in the context too
+		if user != nil {
+			if app.now().Sub(session.LastSeen) >= sessionTouchInterval {
+				if err := app.userSessions.Touch(session.ID, clientIP(r)); err != nil {
+					app.serverError(w, r, err)
+					return
+				}
+			}
+
+			ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+			ctx = context.WithValue(ctx, userSessionIDContextKey, session.ID)
 			r = r.WithContext(ctx)
 		}
 
@@ -117,3 +243,105 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r)
 	})
 }
+
+// authenticate a JSON API request from the API token in its "Authorization: Bearer" header.
+// requests without a token carry on anonymously, but bad or expired tokens are rejected straight away
+func (app *application) apiAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// tell caches that the response depends on who is asking
+		w.Header().Add("Vary", "Authorization")
+
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// the header should look like "Bearer sbx_..."
+		scheme, plaintext, ok := strings.Cut(header, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		token, err := app.tokens.Authenticate(strings.TrimSpace(plaintext))
+		if err != nil {
+			if errors.Is(err, models.ErrInvalidToken) {
+				app.apiInvalidToken(w, r)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+
+		// the token's owner might have been removed or disabled since it was created
+		user, err := app.users.Get(token.UserID)
+		if err != nil {
+			if errors.Is(err, models.ErrNoRecord) {
+				app.apiInvalidToken(w, r)
+			} else {
+				app.apiServerError(w, r, err)
+			}
+			return
+		}
+		if user.Disabled {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		// set the same context value as authenticate() does for a session, plus the token
+		// itself so that apiRequireScope can check its scopes
+		ctx := context.WithValue(r.Context(), authenticatedUserContextKey, user)
+		ctx = context.WithValue(ctx, apiTokenContextKey, token)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// the JSON API version of requireAuthentication. there is no login page to redirect to,
+// so anonymous requests get a 401
+func (app *application) apiRequireAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.isAuthenticated(r) {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			app.apiError(w, r, http.StatusUnauthorized, "you must be authenticated to access this resource")
+			return
+		}
+
+		w.Header().Add("Cache-Control", "no-store")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// return middleware which only lets through requests whose API token has been given scope.
+// it goes after apiRequireAuthentication in a chain
+func (app *application) apiRequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := r.Context().Value(apiTokenContextKey).(*models.Token)
+			if !ok || !token.HasScope(scope) {
+				w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+				app.apiError(w, r, http.StatusForbidden, fmt.Sprintf("your token needs the %s scope to access this resource", scope))
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// the JSON API version of requireActivation. it goes after apiRequireAuthentication in a chain
+func (app *application) apiRequireActivation(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := app.authenticatedUser(r)
+		if user == nil {
+			app.apiInvalidToken(w, r)
+			return
+		}
+
+		if !user.Activated {
+			app.apiError(w, r, http.StatusForbidden, "your account must be activated to access this resource")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
@@ -2,12 +2,19 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"net/netip"
+	"regexp"
+	"strings"
 	"testing"
 
 	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
 )
 
 func TestSecureHeaders(t *testing.T) {
@@ -59,3 +66,202 @@ func TestSecureHeaders(t *testing.T) {
 	bytes.TrimSpace(body)
 	assert.Equal(t, string(body), "OK")
 }
+
+func TestRequireRole(t *testing.T) {
+	app := newTestApplication(t)
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	})
+	handler := app.requireRole(models.RoleModerator)(next)
+
+	tests := []struct {
+		name     string
+		user     *models.User
+		wantCode int
+	}{
+		{"Anonymous", nil, http.StatusForbidden},
+		{"User", &models.User{ID: 1, Role: models.RoleUser}, http.StatusForbidden},
+		{"Moderator", &models.User{ID: 1, Role: models.RoleModerator}, http.StatusOK},
+		{"Admin", &models.User{ID: 1, Role: models.RoleAdmin}, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.user != nil {
+				r = r.WithContext(context.WithValue(r.Context(), authenticatedUserContextKey, tt.user))
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+			assert.Equal(t, rr.Code, tt.wantCode)
+		})
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	var seen string
+	handler := requestID(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen, _ = r.Context().Value(requestIDContextKey).(string)
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		keep   bool
+	}{
+		{"Missing", "", false},
+		{"Valid", "lb-1234.abcd:5", true},
+		{"Bad characters", "abc def<script>", false},
+		{"Too long", strings.Repeat("a", maxRequestIDLength+1), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := http.NewRequest(http.MethodGet, "/", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.header != "" {
+				r.Header.Set(requestIDHeader, tt.header)
+			}
+
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			// the handler and the client see the same id
+			id := rr.Header().Get(requestIDHeader)
+			assert.Equal(t, seen, id)
+			if tt.keep {
+				assert.Equal(t, id, tt.header)
+			} else {
+				assert.Equal(t, regexp.MustCompile(`^[0-9a-f]{32}$`).MatchString(id), true)
+			}
+		})
+	}
+}
+
+func TestRealIP(t *testing.T) {
+	app := newTestApplication(t)
+	app.trustedProxies = []netip.Prefix{
+		netip.MustParsePrefix("10.0.0.0/8"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+
+	var seen string
+	handler := app.realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = clientIP(r)
+	}))
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"No proxy", "192.0.2.1:1234", nil, "192.0.2.1"},
+		{"Untrusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "192.0.2.1"},
+		{"Trusted proxy", "10.0.0.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"Trusted proxy without header", "10.0.0.1:1234", nil, "10.0.0.1"},
+		{"Spoofed by the client", "10.0.0.1:1234", []string{"203.0.113.9, 198.51.100.1"}, "198.51.100.1"},
+		{"Chain of proxies", "10.0.0.1:1234", []string{"198.51.100.1, 10.0.0.2", "10.0.0.3"}, "198.51.100.1"},
+		{"Only proxies", "10.0.0.1:1234", []string{"10.0.0.2"}, "10.0.0.2"},
+		{"IPv6 proxy", "[2001:db8::1]:1234", []string{"2001:db8:ffff::1, ::ffff:198.51.100.1"}, "198.51.100.1"},
+		{"Garbage", "10.0.0.1:1234", []string{"198.51.100.1, unknown"}, "10.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, h := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", h)
+			}
+
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+			assert.Equal(t, seen, tt.want)
+		})
+	}
+
+	// with no trusted proxies the header is ignored
+	app.trustedProxies = nil
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Forwarded-For", "198.51.100.1")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+	assert.Equal(t, seen, "10.0.0.1")
+}
+
+func TestLogRequest(t *testing.T) {
+	app := newTestApplication(t)
+	var logs bytes.Buffer
+	app.logger = newLogger(&logs, "json", "info")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("hello"))
+	})
+	mux.HandleFunc("/error", func(w http.ResponseWriter, r *http.Request) {
+		app.serverError(w, r, errors.New("boom"))
+	})
+	mux.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("oops")
+	})
+	handler := requestID(app.logRequest(app.recoverPanic(mux)))
+
+	// return the lines logged while serving a request to path
+	serve := func(path string) []map[string]any {
+		t.Helper()
+		logs.Reset()
+
+		r, err := http.NewRequest(http.MethodGet, path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set(requestIDHeader, "test-id")
+		handler.ServeHTTP(httptest.NewRecorder(), r)
+
+		var lines []map[string]any
+		for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+			var entry map[string]any
+			if err := json.Unmarshal([]byte(line), &entry); err != nil {
+				t.Fatal(err)
+			}
+			lines = append(lines, entry)
+		}
+		return lines
+	}
+
+	lines := serve("/ok")
+	assert.Equal(t, len(lines), 1)
+	assert.Equal(t, lines[0]["msg"], any("request"))
+	assert.Equal(t, lines[0]["request_id"], any("test-id"))
+	assert.Equal(t, lines[0]["uri"], any("/ok"))
+	assert.Equal(t, lines[0]["status"], any(float64(http.StatusOK)))
+	assert.Equal(t, lines[0]["bytes"], any(float64(len("hello"))))
+	_, ok := lines[0]["duration"]
+	assert.Equal(t, ok, true)
+
+	// tokens in the query string are hidden, but the rest of it is kept
+	lines = serve("/error?token=s3cr3t-reset-token&page=2")
+	assert.Equal(t, len(lines), 2)
+	for _, line := range lines {
+		assert.Equal(t, line["uri"], any("/error?page=2&token=REDACTED"))
+	}
+	assert.Equal(t, strings.Contains(logs.String(), "s3cr3t-reset-token"), false)
+
+	// server errors, including panics, are logged with the request id and a stack trace,
+	// and then the request itself is logged with its 500 status
+	for _, path := range []string{"/error", "/panic"} {
+		lines = serve(path)
+		assert.Equal(t, len(lines), 2)
+		assert.Equal(t, lines[0]["level"], any("ERROR"))
+		assert.Equal(t, lines[0]["request_id"], any("test-id"))
+		assert.Equal(t, strings.Contains(lines[0]["trace"].(string), "goroutine"), true)
+		assert.Equal(t, lines[1]["status"], any(float64(http.StatusInternalServerError)))
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// the reaper is a background worker which deletes expired snippets from the database. the
+// queries in the models already hide expired snippets, but without the reaper they would
+// stay in the snippets table forever.
+//
+// it also keeps an eye on the sessions table. deleting expired sessions is the job of the
+// scs session store's own cleanup goroutine, so the reaper only reports whether it is keeping up.
+//
+// when they are set, the reaper also deletes expired login throttle entries and the records
+// of expired logged in sessions.
+type reaper struct {
+	snippets     models.SnippetModelInterface
+	sessions     models.SessionModelInterface
+	userSessions models.UserSessionModelInterface
+	throttle     models.ThrottleModelInterface
+	now          func() time.Time
+	logger       *slog.Logger
+	interval     time.Duration // how often to run
+	batchSize    int           // maximum number of snippets to delete in one statement
+
+	// the number of expired sessions seen on the previous run
+	lastExpiredSessions int
+}
+
+// run the reaper once straight away and then every interval, until ctx is cancelled.
+// this is meant to be started with app.background() so that shutdown waits for it
+func (rp *reaper) Run(ctx context.Context) {
+	ticker := time.NewTicker(rp.interval)
+	defer ticker.Stop()
+
+	for {
+		rp.run(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// the batch size the reaper uses when it isn't given a sensible one
+const defaultReapBatch = 1000
+
+// do a single pass: delete expired snippets in batches, then check up on the sessions table
+func (rp *reaper) run(ctx context.Context) {
+	var total int
+
+	// a batch size of zero would delete nothing and never come up short, so we'd loop forever
+	batchSize := rp.batchSize
+	if batchSize < 1 {
+		batchSize = defaultReapBatch
+	}
+
+	// stop between batches if we're asked to shut down
+	for ctx.Err() == nil {
+		n, err := rp.snippets.DeleteExpired(batchSize)
+		if err != nil {
+			rp.logger.Error("deleting expired snippets", "error", err)
+			break
+		}
+		total += n
+
+		// a short batch means there is nothing left to delete
+		if n < batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		rp.logger.Info("removed expired snippets", "count", total)
+	}
+
+	if rp.throttle != nil {
+		n, err := rp.throttle.DeleteExpired(rp.now())
+		if err != nil {
+			rp.logger.Error("deleting expired throttle entries", "error", err)
+		} else if n > 0 {
+			rp.logger.Info("removed expired login throttle records", "count", n)
+		}
+	}
+
+	if rp.userSessions != nil {
+		n, err := rp.userSessions.DeleteExpired()
+		if err != nil {
+			rp.logger.Error("deleting expired user sessions", "error", err)
+		} else if n > 0 {
+			rp.logger.Info("removed expired user sessions", "count", n)
+		}
+	}
+
+	expired, err := rp.sessions.CountExpired()
+	if err != nil {
+		rp.logger.Error("counting expired sessions", "error", err)
+		return
+	}
+
+	// the session store cleans up far more often than we run, so a few expired sessions
+	// are normal. if the number isn't going down between runs then the cleanup has fallen behind
+	switch {
+	case expired > 0 && expired >= rp.lastExpiredSessions && rp.lastExpiredSessions > 0:
+		rp.logger.Error("session cleanup is not keeping up", "expired", expired, "previous", rp.lastExpiredSessions)
+	case expired > 0:
+		rp.logger.Info("expired sessions waiting for session store cleanup", "expired", expired)
+	}
+	rp.lastExpiredSessions = expired
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models/mocks"
+	"snippetbox.lets-go/internal/throttle"
+)
+
+// stand-in for the sessions table which reports a fixed sequence of expired session counts
+type fakeSessions struct {
+	counts []int
+	err    error
+}
+
+func (f *fakeSessions) CountExpired() (int, error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	n := f.counts[0]
+	if len(f.counts) > 1 {
+		f.counts = f.counts[1:]
+	}
+	return n, nil
+}
+
+// create a reaper for the tests, which logs into the returned buffer
+func newTestReaper(sessions *fakeSessions) (*reaper, *mocks.SnippetModel, *bytes.Buffer) {
+	snippets := mocks.NewSnippetModel()
+	var logs bytes.Buffer
+	rp := &reaper{
+		snippets:  snippets,
+		sessions:  sessions,
+		logger:    newLogger(&logs, "text", "info"),
+		interval:  time.Hour,
+		batchSize: 2,
+	}
+	return rp, snippets, &logs
+}
+
+func TestReaperDeletesInBatches(t *testing.T) {
+	rp, snippets, logs := newTestReaper(&fakeSessions{counts: []int{0}})
+
+	// five expired snippets need three batches of two
+	for i := 0; i < 5; i++ {
+		snippets.Insert(1, "Expired", "gone", -1)
+	}
+	rp.run(context.Background())
+
+	assert.Equal(t, strings.Contains(logs.String(), `msg="removed expired snippets" count=5`), true)
+
+	// the unexpired fixture snippet is left alone
+	_, err := snippets.Get(mocks.MockSnippet.ID)
+	assert.NilError(t, err)
+}
+
+func TestReaperZeroBatchSize(t *testing.T) {
+	rp, snippets, logs := newTestReaper(&fakeSessions{counts: []int{0}})
+	rp.batchSize = 0
+
+	// the run finishes, using the default batch size, rather than spinning forever
+	for i := 0; i < 3; i++ {
+		snippets.Insert(1, "Expired", "gone", -1)
+	}
+	done := make(chan struct{})
+	go func() {
+		rp.run(context.Background())
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not finish")
+	}
+
+	assert.Equal(t, strings.Contains(logs.String(), `msg="removed expired snippets" count=3`), true)
+}
+
+func TestReaperSessionCleanup(t *testing.T) {
+	rp, _, logs := newTestReaper(&fakeSessions{counts: []int{3, 1, 4}})
+
+	rp.run(context.Background())
+	assert.Equal(t, strings.Contains(logs.String(), `msg="expired sessions waiting for session store cleanup" expired=3`), true)
+	assert.Equal(t, strings.Contains(logs.String(), "level=ERROR"), false)
+
+	// going down is fine
+	rp.run(context.Background())
+	assert.Equal(t, strings.Contains(logs.String(), "level=ERROR"), false)
+
+	// going up means the cleanup has fallen behind
+	rp.run(context.Background())
+	assert.Equal(t, strings.Contains(logs.String(), `level=ERROR msg="session cleanup is not keeping up" expired=4 previous=1`), true)
+}
+
+func TestReaperLogsErrors(t *testing.T) {
+	rp, _, logs := newTestReaper(&fakeSessions{err: errors.New("boom")})
+
+	rp.run(context.Background())
+	assert.Equal(t, strings.Contains(logs.String(), `level=ERROR msg="counting expired sessions" error=boom`), true)
+}
+
+func TestReaperStop(t *testing.T) {
+	rp, _, _ := newTestReaper(&fakeSessions{counts: []int{0}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		rp.Run(ctx)
+		close(stopped)
+	}()
+
+	// Run() must return once the context is cancelled
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("reaper did not stop")
+	}
+}
+
+func TestReaperDeletesExpiredThrottleEntries(t *testing.T) {
+	rp, _, logs := newTestReaper(&fakeSessions{counts: []int{0}})
+
+	now := time.Now()
+	store := throttle.NewMemoryStore()
+	store.Add("login:ip:192.0.2.1", now.Add(-time.Hour), now.Add(-time.Minute))
+	store.Add("login:ip:192.0.2.2", now, now.Add(time.Minute))
+	rp.throttle = store
+	rp.now = func() time.Time { return now }
+
+	rp.run(context.Background())
+	assert.Equal(t, strings.Contains(logs.String(), `msg="removed expired login throttle records" count=1`), true)
+
+	e, err := store.Get("login:ip:192.0.2.2", now)
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 1)
+}
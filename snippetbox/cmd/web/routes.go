@@ -3,6 +3,7 @@ package main
 import (
 	"net/http"
 
+	"snippetbox.lets-go/internal/models"
 	"snippetbox.lets-go/ui"
 
 	"github.com/julienschmidt/httprouter"
@@ -12,13 +13,26 @@ import (
 // this method initializes our servemux with our routes for the web application
 func (app *application) routes() http.Handler {
 
-	// initialize our router
-	router := httprouter.New()
+	// initialize our router. it is wrapped so that the metrics for each request are labelled
+	// with the pattern of the route it matched
+	router := instrumentedRouter{httprouter.New()}
 
-	// handler for 404s
+	// handlers for 404s and 405s. API clients get a JSON error rather than plain text.
+	// httprouter has already set the Allow header by the time MethodNotAllowed is called
 	router.NotFound = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAPIRequest(r) {
+			app.apiNotFound(w, r)
+			return
+		}
 		app.notFound(w)
 	})
+	router.MethodNotAllowed = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAPIRequest(r) {
+			app.apiClientError(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		app.clientError(w, http.StatusMethodNotAllowed)
+	})
 
 	// take the ui.Files embedded filesystem and convert it to a http.FS type so
 	// that it satisfies the http.FileSystem interface. We then pass that to http.FileServer()
@@ -34,6 +48,10 @@ func (app *application) routes() http.Handler {
 	// ping method for testing our server
 	router.HandlerFunc(http.MethodGet, "/ping", ping)
 
+	// liveness check for orchestrators and load balancers. the readiness check is only on the
+	// admin listener, since every request to it queries the database and the session store
+	router.HandlerFunc(http.MethodGet, "/healthz", app.healthz)
+
 	// unprotected app routes use the "dynamic" middleware chain
 	dynamic := alice.New(
 		app.sessionManager.LoadAndSave,
@@ -43,6 +61,10 @@ func (app *application) routes() http.Handler {
 	// home and view
 	router.Handler(http.MethodGet, "/", dynamic.ThenFunc(app.home))
 	router.Handler(http.MethodGet, "/snippet/view/:id", dynamic.ThenFunc(app.snippetView))
+	router.Handler(http.MethodGet, "/snippet/user/:id", dynamic.ThenFunc(app.snippetUser))
+
+	// search
+	router.Handler(http.MethodGet, "/search", dynamic.ThenFunc(app.search))
 
 	// signup
 	router.Handler(http.MethodGet, "/user/signup", dynamic.ThenFunc(app.userSignup))
@@ -51,20 +73,89 @@ func (app *application) routes() http.Handler {
 	// login
 	router.Handler(http.MethodGet, "/user/login", dynamic.ThenFunc(app.userLogin))
 	router.Handler(http.MethodPost, "/user/login", dynamic.ThenFunc(app.userLoginPost))
+	router.Handler(http.MethodGet, "/user/login/verify", dynamic.ThenFunc(app.userLoginVerify))
+	router.Handler(http.MethodPost, "/user/login/verify", dynamic.ThenFunc(app.userLoginVerifyPost))
+
+	// account activation, from the link in the activation email
+	router.Handler(http.MethodGet, "/user/activate", dynamic.ThenFunc(app.userActivate))
+	router.Handler(http.MethodPost, "/user/activate", dynamic.ThenFunc(app.userActivatePost))
+
+	// password reset, for users who can't log in
+	router.Handler(http.MethodGet, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgot))
+	router.Handler(http.MethodPost, "/user/password/forgot", dynamic.ThenFunc(app.userPasswordForgotPost))
+	router.Handler(http.MethodGet, "/user/password/reset", dynamic.ThenFunc(app.userPasswordReset))
+	router.Handler(http.MethodPost, "/user/password/reset", dynamic.ThenFunc(app.userPasswordResetPost))
 
 	// protected (authenticated-only) app routes, using a new "protected"
 	// middleware chain which includes the requireAuthentication middleware
 	protected := dynamic.Append(app.requireAuthentication)
 
+	// only users who have verified their email address can create snippets
+	activated := protected.Append(app.requireActivation)
+
 	// snippet create
-	router.Handler(http.MethodGet, "/snippet/create", protected.ThenFunc(app.snippetCreate))
-	router.Handler(http.MethodPost, "/snippet/create", protected.ThenFunc(app.snippetCreatePost))
+	router.Handler(http.MethodGet, "/snippet/create", activated.ThenFunc(app.snippetCreate))
+	router.Handler(http.MethodPost, "/snippet/create", activated.ThenFunc(app.snippetCreatePost))
+
+	// snippet edit and delete. the handlers check that the user owns the snippet
+	router.Handler(http.MethodGet, "/snippet/edit/:id", protected.ThenFunc(app.snippetEdit))
+	router.Handler(http.MethodPost, "/snippet/edit/:id", protected.ThenFunc(app.snippetEditPost))
+	router.Handler(http.MethodPost, "/snippet/delete/:id", protected.ThenFunc(app.snippetDeletePost))
 
 	// logout
 	router.Handler(http.MethodPost, "/user/logout", protected.ThenFunc(app.userLogoutPost))
 
+	// account details and password change
+	router.Handler(http.MethodGet, "/account/view", protected.ThenFunc(app.accountView))
+	router.Handler(http.MethodGet, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdate))
+	router.Handler(http.MethodPost, "/account/password/update", protected.ThenFunc(app.accountPasswordUpdatePost))
+	router.Handler(http.MethodPost, "/account/activation/resend", protected.ThenFunc(app.accountActivationResendPost))
+
+	// logged in sessions, which are listed on the account page
+	router.Handler(http.MethodPost, "/account/sessions/revoke/:id", protected.ThenFunc(app.accountSessionsRevokePost))
+	router.Handler(http.MethodPost, "/account/sessions/revoke-others", protected.ThenFunc(app.accountSessionsRevokeOthersPost))
+
+	// two-factor authentication
+	router.Handler(http.MethodGet, "/account/2fa", protected.ThenFunc(app.accountTwoFactor))
+	router.Handler(http.MethodGet, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnable))
+	router.Handler(http.MethodGet, "/account/2fa/qr.png", protected.ThenFunc(app.accountTwoFactorQR))
+	router.Handler(http.MethodPost, "/account/2fa/enable", protected.ThenFunc(app.accountTwoFactorEnablePost))
+	router.Handler(http.MethodPost, "/account/2fa/disable", protected.ThenFunc(app.accountTwoFactorDisablePost))
+
+	// API tokens
+	router.Handler(http.MethodGet, "/account/tokens", protected.ThenFunc(app.accountTokens))
+	router.Handler(http.MethodPost, "/account/tokens", protected.ThenFunc(app.accountTokensPost))
+	router.Handler(http.MethodPost, "/account/tokens/delete/:id", protected.ThenFunc(app.accountTokensDeletePost))
+
+	// the admin pages, for admins only
+	admin := protected.Append(app.requireRole(models.RoleAdmin))
+
+	router.Handler(http.MethodGet, "/admin", admin.ThenFunc(app.adminDashboard))
+	router.Handler(http.MethodGet, "/admin/users", admin.ThenFunc(app.adminUsers))
+	router.Handler(http.MethodPost, "/admin/users/disable/:id", admin.ThenFunc(app.adminUserDisablePost))
+	router.Handler(http.MethodPost, "/admin/users/enable/:id", admin.ThenFunc(app.adminUserEnablePost))
+	router.Handler(http.MethodPost, "/admin/users/role/:id", admin.ThenFunc(app.adminUserRolePost))
+	router.Handler(http.MethodGet, "/admin/snippets", admin.ThenFunc(app.adminSnippets))
+	router.Handler(http.MethodPost, "/admin/snippets/expire/:id", admin.ThenFunc(app.adminSnippetExpirePost))
+
+	// the JSON API has its own middleware chains. API clients aren't browsers, so there are
+	// no sessions and no CSRF tokens. instead they authenticate every request with an API
+	// token, and changing snippets needs a token with the write scope
+	api := alice.New(app.apiAuthenticate)
+	apiProtected := api.Append(app.apiRequireAuthentication, app.apiRequireScope(models.ScopeWrite))
+
+	router.Handler(http.MethodGet, "/api/v1/snippets", api.ThenFunc(app.apiSnippetList))
+	router.Handler(http.MethodGet, "/api/v1/snippets/:id", api.ThenFunc(app.apiSnippetGet))
+	router.Handler(http.MethodPost, "/api/v1/snippets", apiProtected.Append(app.apiRequireActivation).ThenFunc(app.apiSnippetCreate))
+	router.Handler(http.MethodPut, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetUpdate))
+	router.Handler(http.MethodDelete, "/api/v1/snippets/:id", apiProtected.ThenFunc(app.apiSnippetDelete))
+
 	// create a middleware chain containing the standard middleware which will be used for
-	// every request that our app receives
-	standard := alice.New(app.recoverPanic, app.logRequest, secureHeaders)
+	// every request that our app receives. the request id comes first so that everything
+	// after it can log it, and realIP next so that the logs and the login throttle see the
+	// client's address rather than our proxy's. logRequest and instrument go outside
+	// recoverPanic so that requests which panic are still logged and counted, with their 500
+	// status
+	standard := alice.New(requestID, app.realIP, app.logRequest, app.instrument, app.recoverPanic, secureHeaders)
 	return standard.Then(router)
 }
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"runtime/debug"
+	"syscall"
+	"time"
+
+	"snippetbox.lets-go/internal/config"
+)
+
+// run fn in a background goroutine which the server waits for during graceful shutdown.
+// fn is passed a context which is cancelled when the server starts shutting down, and it
+// should return promptly once that happens. any panic in fn is logged rather than crashing the app
+func (app *application) background(fn func(ctx context.Context)) {
+	app.wg.Add(1)
+
+	go func() {
+		defer app.wg.Done()
+
+		// same idea as the recoverPanic middleware, but there is no response to write to
+		defer func() {
+			if err := recover(); err != nil {
+				app.logger.Error(fmt.Sprint(err), "trace", string(debug.Stack()))
+			}
+		}()
+
+		fn(app.backgroundCtx)
+	}()
+}
+
+// render and send an email in the background, so that the request doesn't wait on the
+// mail server. failures are logged, since by then there is nobody to tell
+func (app *application) sendEmail(recipient, templateFile string, data any) {
+	app.background(func(ctx context.Context) {
+		err := app.mailer.Send(recipient, templateFile, data)
+		if err != nil {
+			app.logger.Error("sending email", "template", templateFile, "recipient", recipient, "error", err)
+		}
+	})
+}
+
+// start the HTTPS server and block until it has shut down. when the process receives SIGINT
+// or SIGTERM the server stops accepting new connections and gives in-flight requests and
+// background goroutines up to cfg.ShutdownTimeout to finish. before that, /readyz starts
+// failing and we carry on serving for cfg.ShutdownDelay, unless there is no admin listener to
+// serve /readyz. a nil return value means the shutdown was clean.
+//
+// plain is the HTTP server which redirects to HTTPS and answers ACME challenges, and admin is
+// the one for the admin listener. either can be nil if it is turned off. admin starts first
+// and stops last, so that the metrics can still be scraped while the app shuts down
+func (app *application) serve(srv, plain, admin *http.Server, cfg *config.Config) error {
+
+	// the plain HTTP listeners are bound before anything else, so that a mistake like a port
+	// which is already in use stops the app rather than leaving it running without them
+	if err := app.startPlain(admin, "admin"); err != nil {
+		return err
+	}
+	if err := app.startPlain(plain, "http"); err != nil {
+		return err
+	}
+
+	// receives the result of the graceful shutdown from the goroutine below
+	shutdownError := make(chan error)
+
+	go func() {
+		// wait for a SIGINT (ctrl+c) or SIGTERM (the default signal from kill, docker, systemd...)
+		quit := make(chan os.Signal, 1)
+		signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+		s := <-quit
+
+		app.logger.Info("shutting down server", "signal", s.String())
+
+		// fail the readiness check, then keep serving for the shutdown delay so that load
+		// balancers notice and stop sending us new requests before we stop accepting them.
+		// without the admin listener they can't see /readyz, so waiting would be pointless
+		app.draining.Store(true)
+		if cfg.ShutdownDelay > 0 && admin != nil {
+			app.logger.Info("draining", "delay", cfg.ShutdownDelay.String())
+			time.Sleep(cfg.ShutdownDelay)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+		defer cancel()
+
+		// Shutdown() stops the listeners and waits for in-flight requests to complete
+		err := srv.Shutdown(ctx)
+		for _, s := range []*http.Server{plain, admin} {
+			if s == nil {
+				continue
+			}
+			if shutdownErr := s.Shutdown(ctx); err == nil {
+				err = shutdownErr
+			}
+		}
+
+		// tell the background goroutines to stop and wait for them, within what's left of the timeout
+		app.stopBackground()
+		done := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(done)
+		}()
+		select {
+		case <-done:
+		case <-ctx.Done():
+			if err == nil {
+				err = fmt.Errorf("waiting for background tasks: %w", ctx.Err())
+			}
+		}
+
+		shutdownError <- err
+	}()
+
+	// listen on a port and start the server
+	// two parameters are passed in, the TCP network address (port :4000) and the servemux
+	// the certificate comes from the files, unless the TLS config has a GetCertificate to get
+	// it from somewhere else
+	certFile, keyFile := cfg.TLSCertFile, cfg.TLSKeyFile
+	if srv.TLSConfig != nil && srv.TLSConfig.GetCertificate != nil {
+		certFile, keyFile = "", ""
+	}
+
+	app.logger.Info("starting server", "addr", srv.Addr)
+	err := srv.ListenAndServeTLS(certFile, keyFile)
+
+	// Shutdown() makes ListenAndServeTLS() return http.ErrServerClosed straight away. any other
+	// error means the server failed on its own, for example because the port was in use
+	if !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+
+	err = <-shutdownError
+	if err != nil {
+		return err
+	}
+
+	app.logger.Info("stopped server", "addr", srv.Addr)
+	return nil
+}
+
+// bind the listener for s, a plain HTTP server called name, and serve it in the background.
+// s can be nil, in which case there's nothing to do
+func (app *application) startPlain(s *http.Server, name string) error {
+	if s == nil {
+		return nil
+	}
+
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	app.logger.Info("starting "+name+" server", "addr", s.Addr)
+	go func() {
+		err := s.Serve(ln)
+		if !errors.Is(err, http.ErrServerClosed) {
+			app.logger.Error(name+" server stopped", "error", err)
+		}
+	}()
+	return nil
+}
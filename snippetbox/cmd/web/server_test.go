@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+func TestBackground(t *testing.T) {
+	app := newTestApplication(t)
+
+	stopped := false
+	app.background(func(ctx context.Context) {
+		<-ctx.Done()
+		stopped = true
+	})
+
+	// a panicking task must not take the app down with it
+	app.background(func(ctx context.Context) {
+		panic("boom")
+	})
+
+	app.stopBackground()
+
+	done := make(chan struct{})
+	go func() {
+		app.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("background tasks did not finish")
+	}
+	assert.Equal(t, stopped, true)
+}
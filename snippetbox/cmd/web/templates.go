@@ -1,10 +1,16 @@
 package main
 
 import (
+	"fmt"
 	"html/template"
 	"io/fs"
+	"net/url"
 	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 
 	"snippetbox.lets-go/ui"
 
@@ -13,13 +19,90 @@ import (
 
 // acts as structure to hold dynamic data that we want to pass to HTML templates
 type templateData struct {
-	CurrentYear     int
-	Snippet         *models.Snippet
-	Snippets        []*models.Snippet
-	Form            any
-	Flash           string // for holding string data to flash to user once upon certain request
-	IsAuthenticated bool
-	CSRFToken       string
+	Now                 time.Time // from app.now, so that tests with a fake clock see it too
+	CurrentYear         int
+	Snippet             *models.Snippet
+	Snippets            []*models.Snippet
+	Form                any
+	Flash               string // for holding string data to flash to user once upon certain request
+	IsAuthenticated     bool
+	AuthenticatedUserID int          // zero if the user is not logged in
+	AuthenticatedUser   *models.User // nil if the user is not logged in
+	CSRFToken           string
+	Pagination          *pagination  // links rendered by the "pagination" partial
+	Query               string       // the search terms on the search page
+	User                *models.User // the logged in user, on the account page
+	Tokens              []*models.Token
+	NewToken            string // a plain-text API token, shown once after it is created
+	TwoFactorEnabled    bool
+	TwoFactorSecret     string   // the TOTP secret being set up, for typing into an app by hand
+	RecoveryCodes       []string // plain-text recovery codes, shown once after they are made
+	RecoveryCodesLeft   int
+	Sessions            []*models.UserSession // the user's logged in sessions, on the account page
+	CurrentSessionID    int                   // the id of the session making the request
+	Users               []*models.User        // the users listed on the admin pages
+	AdminStats          *models.AdminStats
+	DailyCounts         []models.DailyCount // signups and snippets per day, on the admin dashboard
+	DailyMax            int                 // the largest count in DailyCounts, for scaling the bars
+}
+
+// report whether the logged in user has role, or a role above it. templates use it like
+// {{if .HasRole "moderator"}}, and it is false when nobody is logged in
+func (data *templateData) HasRole(role string) bool {
+	return data.AuthenticatedUser != nil && data.AuthenticatedUser.HasRole(role)
+}
+
+// return every role, from the least to the most powerful, for the role menus on the admin pages
+func (data *templateData) Roles() []string {
+	return models.Roles
+}
+
+// report whether t has passed, like a snippet's expiry time on the admin pages. templates use
+// it like {{if $.Expired .Expires}}
+func (data *templateData) Expired(t time.Time) bool {
+	return !t.After(data.Now)
+}
+
+// holds the links to the neighbouring pages of a paginated listing. an empty
+// string means there is no page in that direction.
+type pagination struct {
+	PrevURL string
+	NextURL string
+}
+
+// build the pagination links for a keyset-paginated listing served at path. the previous
+// page holds newer snippets and the next page holds older ones
+func newCursorPagination(path string, page models.Page) *pagination {
+	p := &pagination{}
+	if page.HasNewer && page.NewestID > 0 {
+		p.PrevURL = fmt.Sprintf("%s?before=%d", path, page.NewestID)
+	}
+	if page.HasOlder && page.OldestID > 0 {
+		p.NextURL = fmt.Sprintf("%s?after=%d", path, page.OldestID)
+	}
+	return p
+}
+
+// build the pagination links for a listing served at path which is split into numbered pages.
+// values holds any other query string parameters which need to be carried between pages
+func newPagePagination(path string, values url.Values, page int, more bool) *pagination {
+	link := func(n int) string {
+		v := url.Values{}
+		for key, val := range values {
+			v[key] = val
+		}
+		v.Set("page", strconv.Itoa(n))
+		return path + "?" + v.Encode()
+	}
+
+	p := &pagination{}
+	if page > 1 {
+		p.PrevURL = link(page - 1)
+	}
+	if more {
+		p.NextURL = link(page + 1)
+	}
+	return p
 }
 
 // func to format date in a human-readable form
@@ -32,11 +115,151 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// func to describe a duration in words, like "1 hour" or "30 minutes". it uses the largest
+// of days, hours or minutes which divides d exactly, so 90 minutes stays "90 minutes"
+func humanDuration(d time.Duration) string {
+	n, unit := int(d/time.Minute), "minute"
+	switch {
+	case d >= 24*time.Hour && d%(24*time.Hour) == 0:
+		n, unit = int(d/(24*time.Hour)), "day"
+	case d >= time.Hour && d%time.Hour == 0:
+		n, unit = int(d/time.Hour), "hour"
+	}
+	if n != 1 {
+		unit += "s"
+	}
+	return fmt.Sprintf("%d %s", n, unit)
+}
+
+// split a search query into the words we want to highlight, ignoring the operators
+// that MySQL full-text search understands
+func searchTerms(query string) []string {
+	var terms []string
+	for _, f := range strings.Fields(query) {
+		f = strings.Trim(f, `+-<>()~*"`)
+		if f != "" {
+			terms = append(terms, regexp.QuoteMeta(f))
+		}
+	}
+	return terms
+}
+
+// func to escape text for HTML and wrap every case-insensitive occurrence of the words
+// in query with a <mark> element
+func highlight(text, query string) template.HTML {
+	terms := searchTerms(query)
+	if len(terms) == 0 {
+		return template.HTML(template.HTMLEscapeString(text))
+	}
+
+	rx := regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+
+	// escape the text between the matches separately from the matches themselves, so that
+	// the only markup in the result is the <mark> elements we add
+	var b strings.Builder
+	last := 0
+	for _, loc := range rx.FindAllStringIndex(text, -1) {
+		b.WriteString(template.HTMLEscapeString(text[last:loc[0]]))
+		b.WriteString("<mark>")
+		b.WriteString(template.HTMLEscapeString(text[loc[0]:loc[1]]))
+		b.WriteString("</mark>")
+		last = loc[1]
+	}
+	b.WriteString(template.HTMLEscapeString(text[last:]))
+	return template.HTML(b.String())
+}
+
+// func to cut text down to at most n characters, centred where possible on the first
+// word from query that appears in it. an ellipsis marks any text that was cut off
+func excerpt(text, query string, n int) string {
+	runes := []rune(text)
+	if len(runes) <= n {
+		return text
+	}
+
+	start := 0
+	if terms := searchTerms(query); len(terms) > 0 {
+		rx := regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+		if loc := rx.FindStringIndex(text); loc != nil {
+			start = utf8.RuneCountInString(text[:loc[0]]) - n/4
+		}
+	}
+	if start < 0 {
+		start = 0
+	}
+	if start > len(runes)-n {
+		start = len(runes) - n
+	}
+
+	out := string(runes[start : start+n])
+	if start > 0 {
+		out = "…" + out
+	}
+	if start+n < len(runes) {
+		out = out + "…"
+	}
+	return out
+}
+
+// the browsers and operating systems that device() knows about, checked in order. the order
+// matters because user agents name the browsers they are compatible with too, so every
+// Chrome says "Safari" and every Edge says "Chrome"
+var (
+	browsers = []struct{ token, name string }{
+		{"Edg/", "Edge"},
+		{"OPR/", "Opera"},
+		{"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"},
+		{"Safari/", "Safari"},
+		{"curl/", "curl"},
+	}
+	platforms = []struct{ token, name string }{
+		{"Android", "Android"},
+		{"iPhone", "iPhone"},
+		{"iPad", "iPad"},
+		{"Windows", "Windows"},
+		{"Mac OS X", "macOS"},
+		{"CrOS", "ChromeOS"},
+		{"Linux", "Linux"},
+	}
+)
+
+// func to describe the device behind a user agent in a few words, like "Firefox on Linux".
+// user agents are easy to fake, so this is only a hint to help users recognise their sessions
+func device(userAgent string) string {
+	browser, platform := "", ""
+	for _, b := range browsers {
+		if strings.Contains(userAgent, b.token) {
+			browser = b.name
+			break
+		}
+	}
+	for _, p := range platforms {
+		if strings.Contains(userAgent, p.token) {
+			platform = p.name
+			break
+		}
+	}
+
+	switch {
+	case browser != "" && platform != "":
+		return browser + " on " + platform
+	case browser != "":
+		return browser
+	case platform != "":
+		return platform
+	}
+	return "Unknown device"
+}
+
 // initialize a FuncMap and store it as a global variable.
 // this is basically a string-keyed map which acts as a lookup between the names
 // of our custom template functions
 var functions = template.FuncMap{
 	"humanDate": humanDate,
+	"highlight": highlight,
+	"excerpt":   excerpt,
+	"device":    device,
 }
 
 func newTemplateCache() (map[string]*template.Template, error) {
@@ -5,6 +5,7 @@ import (
 	"time"
 
 	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
 )
 
 func TestHumanDate(t *testing.T) {
@@ -56,3 +57,170 @@ func TestHumanDate(t *testing.T) {
 	}
 
 }
+
+func TestHumanDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Minute, "1 minute"},
+		{30 * time.Minute, "30 minutes"},
+		{90 * time.Minute, "90 minutes"},
+		{time.Hour, "1 hour"},
+		{2 * time.Hour, "2 hours"},
+		{48 * time.Hour, "2 days"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, humanDuration(tt.d), tt.want)
+		})
+	}
+}
+
+func TestNewCursorPagination(t *testing.T) {
+	tests := []struct {
+		name     string
+		page     models.Page
+		wantPrev string
+		wantNext string
+	}{
+		{
+			name:     "Middle",
+			page:     models.Page{NewestID: 20, OldestID: 11, HasNewer: true, HasOlder: true},
+			wantPrev: "/?before=20",
+			wantNext: "/?after=11",
+		},
+		{
+			name:     "First",
+			page:     models.Page{NewestID: 30, OldestID: 21, HasOlder: true},
+			wantNext: "/?after=21",
+		},
+		{
+			name:     "Last",
+			page:     models.Page{NewestID: 10, OldestID: 1, HasNewer: true},
+			wantPrev: "/?before=10",
+		},
+		{
+			name: "Empty",
+			page: models.Page{},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newCursorPagination("/", tt.page)
+			assert.Equal(t, p.PrevURL, tt.wantPrev)
+			assert.Equal(t, p.NextURL, tt.wantNext)
+		})
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		want  string
+	}{
+		{
+			name:  "Single word",
+			text:  "An old silent pond",
+			query: "pond",
+			want:  "An old silent <mark>pond</mark>",
+		},
+		{
+			name:  "Case insensitive",
+			text:  "Pond and pond",
+			query: "POND",
+			want:  "<mark>Pond</mark> and <mark>pond</mark>",
+		},
+		{
+			name:  "Operators ignored",
+			text:  "frog jumps",
+			query: "+frog -(jumps",
+			want:  "<mark>frog</mark> <mark>jumps</mark>",
+		},
+		{
+			name:  "Escapes HTML",
+			text:  "<b>frog</b>",
+			query: "frog",
+			want:  "&lt;b&gt;<mark>frog</mark>&lt;/b&gt;",
+		},
+		{
+			name:  "Empty query",
+			text:  "a & b",
+			query: "",
+			want:  "a &amp; b",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, string(highlight(tt.text, tt.query)), tt.want)
+		})
+	}
+}
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		name  string
+		text  string
+		query string
+		n     int
+		want  string
+	}{
+		{
+			name: "Short text",
+			text: "An old silent pond",
+			n:    50,
+			want: "An old silent pond",
+		},
+		{
+			name: "No match",
+			text: "abcdefghij",
+			n:    4,
+			want: "abcd…",
+		},
+		{
+			name:  "Centred on match",
+			text:  "abcdefghijklmnop",
+			query: "ijk",
+			n:     4,
+			want:  "…hijk…",
+		},
+		{
+			name:  "Match at end",
+			text:  "abcdefghij",
+			query: "j",
+			n:     4,
+			want:  "…ghij",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, excerpt(tt.text, tt.query, tt.n), tt.want)
+		})
+	}
+}
+
+func TestDevice(t *testing.T) {
+	tests := []struct {
+		userAgent string
+		want      string
+	}{
+		{"Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/115.0", "Firefox on Linux"},
+		{"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Safari/537.36 Edg/114.0.1823.67", "Edge on Windows"},
+		{"Mozilla/5.0 (iPhone; CPU iPhone OS 16_5 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/16.5 Mobile/15E148 Safari/604.1", "Safari on iPhone"},
+		{"Mozilla/5.0 (Linux; Android 13) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/114.0.0.0 Mobile Safari/537.36", "Chrome on Android"},
+		{"curl/8.0.1", "curl"},
+		{"", "Unknown device"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			assert.Equal(t, device(tt.userAgent), tt.want)
+		})
+	}
+}
@@ -2,19 +2,119 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"html"
 	"io"
-	"log"
+	"io/fs"
+	"log/slog"
 	"net/http"
+	"net/http/cookiejar"
 	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-playground/form/v4"
+	"snippetbox.lets-go/internal/mailer"
+	"snippetbox.lets-go/internal/models/mocks"
+	"snippetbox.lets-go/internal/signer"
+	"snippetbox.lets-go/internal/throttle"
+	"snippetbox.lets-go/ui"
 )
 
+// regular expression which captures the CSRF token value from the HTML for our pages
+var csrfTokenRX = regexp.MustCompile(`<input type="hidden" name="csrf_token" value="(.+)">`)
+
+// helper to pull the CSRF token out of a response body. the token is HTML escaped
+// in the page, so we unescape it before returning it
+func extractCSRFToken(t *testing.T, body string) string {
+	matches := csrfTokenRX.FindStringSubmatch(body)
+	if len(matches) < 2 {
+		t.Fatal("no csrf token found in body")
+	}
+	return html.UnescapeString(string(matches[1]))
+}
+
 // helper which makes an instance of our app struct for mocked dependencies
 func newTestApplication(t *testing.T) *application {
-	return &application{
-		infoLog:  log.New(io.Discard, "", 0),
-		errorLog: log.New(io.Discard, "", 0),
+
+	// create an instance of the template cache
+	templateCache, err := newTemplateCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// and a session manager instance. we use the same settings as production, except that
+	// the sessions are held in memory by the default scs store
+	sessionManager := scs.New()
+	sessionManager.Lifetime = 12 * time.Hour
+	sessionManager.Cookie.Secure = true
+
+	emailTemplates, err := fs.Sub(ui.Files, "email")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sgnr, err := signer.New([]byte(strings.Repeat("k", signer.MinKeyLength)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the admin mock works on the same data as the user and snippet mocks
+	snippets := mocks.NewSnippetModel()
+	users := mocks.NewUserModel()
+
+	app := &application{
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		metrics:        newMetrics(nil),
+		db:             &stubDB{},
+		snippets:       snippets,
+		users:          users,
+		admin:          mocks.NewAdminModel(users, snippets),
+		tokens:         mocks.NewTokenModel(),
+		passwordResets: mocks.NewPasswordResetModel(),
+		templateCache:  templateCache,
+		formDecoder:    form.NewDecoder(),
+		sessionManager: sessionManager,
+		mailer:         mailer.New(&mailer.MemoryOutbox{}, "Snippetbox <no-reply@snippetbox.test>", emailTemplates),
+		baseURL:        "https://snippetbox.test",
+		resetTTL:       time.Hour,
+		signer:         sgnr,
+		activationTTL:  72 * time.Hour,
+		twoFactor:      mocks.NewTwoFactorModel(),
+		userSessions:   mocks.NewUserSessionModel(),
+		now:            time.Now,
+	}
+	throttleStore := throttle.NewMemoryStore()
+	app.setLoginThrottle(throttleStore, 10, 15*time.Minute)
+	app.setResetThrottle(throttleStore)
+	app.backgroundCtx, app.stopBackground = context.WithCancel(context.Background())
+	t.Cleanup(app.stopBackground)
+	return app
+}
+
+// stands in for the database in the readiness check. PingContext returns err
+type stubDB struct {
+	err error
+}
+
+func (db *stubDB) PingContext(ctx context.Context) error {
+	return db.err
+}
+
+// swap the app's mailer for one which keeps emails in the returned outbox. emails are sent
+// in the background, so call app.wg.Wait() before looking in the outbox
+func useMemoryOutbox(t *testing.T, app *application) *mailer.MemoryOutbox {
+	emailTemplates, err := fs.Sub(ui.Files, "email")
+	if err != nil {
+		t.Fatal(err)
 	}
+	outbox := &mailer.MemoryOutbox{}
+	app.mailer = mailer.New(outbox, "Snippetbox <no-reply@snippetbox.test>", emailTemplates)
+	return outbox
 }
 
 // embed a httptest.Server within this testServer struct
@@ -25,6 +125,19 @@ type testServer struct {
 // helper to create a new test server which returns one of our custom testServer structs
 func newTestServer(t *testing.T, h http.Handler) *testServer {
 	ts := httptest.NewTLSServer(h)
+
+	// add a cookie jar to the test server client, so that response cookies are stored
+	// and sent with subsequent requests
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts.Client().Jar = jar
+
+	// stop the client from following redirects, so that we can test the first response
+	ts.Client().CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
 	return &testServer{ts}
 }
 
@@ -42,7 +155,83 @@ func (ts *testServer) get(t *testing.T, urlPath string) (int, http.Header, strin
 	if err != nil {
 		t.Fatal(err)
 	}
-	bytes.TrimSpace(respBody)
+	respBody = bytes.TrimSpace(respBody)
 	return resp.StatusCode, resp.Header, string(respBody)
 
 }
+
+// postForm() sends a POST request to the test server with the form data in the
+// request body, and returns the response status code, headers and body
+func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (int, http.Header, string) {
+	resp, err := ts.Client().PostForm(ts.URL+urlPath, form)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody = bytes.TrimSpace(respBody)
+	return resp.StatusCode, resp.Header, string(respBody)
+}
+
+// helper which logs the test server client in as the fixture user
+func (ts *testServer) login(t *testing.T) {
+	ts.loginAs(t, mocks.MockUser.Email, mocks.MockPassword)
+}
+
+// helper which logs the test server client in as the user with the given email and password
+func (ts *testServer) loginAs(t *testing.T, email, password string) {
+	_, _, body := ts.get(t, "/user/login")
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", password)
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login failed with status %d", code)
+	}
+}
+
+// helper which logs the test server client out again
+func (ts *testServer) logout(t *testing.T) {
+	_, _, body := ts.get(t, "/")
+	form := url.Values{}
+	form.Add("csrf_token", extractCSRFToken(t, body))
+
+	code, _, _ := ts.postForm(t, "/user/logout", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("logout failed with status %d", code)
+	}
+}
+
+// send a request with the given method and body to the test server and return the response
+// status code, headers and body. when token isn't empty it is sent as a bearer token
+func (ts *testServer) request(t *testing.T, method, urlPath, body, token string) (int, http.Header, string) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	respBody = bytes.TrimSpace(respBody)
+	return resp.StatusCode, resp.Header, string(respBody)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/justinas/alice"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+	"snippetbox.lets-go/internal/config"
+)
+
+// create the autocert manager which gets certificates for cfg.Domains() from the ACME server
+// at cfg.ACMEDirectory, and keeps them (with the account key) in cfg.ACMECache. it answers
+// both the TLS-ALPN-01 challenge, on the HTTPS listener, and HTTP-01, on the HTTP listener
+func newACMEManager(cfg *config.Config) (*autocert.Manager, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	// a local test server like pebble serves its directory with a certificate from its own CA
+	if cfg.ACMEDirectoryCA != "" {
+		pem, err := os.ReadFile(cfg.ACMEDirectoryCA)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("acme: no certificates found in %s", cfg.ACMEDirectoryCA)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+
+	client := &acme.Client{
+		DirectoryURL: cfg.ACMEDirectory,
+		HTTPClient: &http.Client{
+			Transport: &orderLocator{next: transport, orders: map[string]string{}},
+		},
+	}
+
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(cfg.ACMECache),
+		HostPolicy: autocert.HostWhitelist(cfg.Domains()...),
+		Client:     client,
+		Email:      cfg.ACMEEmail,
+	}, nil
+}
+
+// a http.RoundTripper for the ACME client which makes sure the response to finalizing an order
+// has the order's URL in its Location header. the acme package polls that URL while the
+// certificate is issued, but RFC 8555 doesn't require the header there, and servers which
+// issue in the background, like pebble, leave it out. so we note the finalize URL of every
+// order we see, and fill the header in from that
+type orderLocator struct {
+	next   http.RoundTripper
+	mu     sync.Mutex
+	orders map[string]string // finalize URL -> order URL
+}
+
+func (ol *orderLocator) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := ol.next.RoundTrip(req)
+	if err != nil || req.Method != http.MethodPost {
+		return res, err
+	}
+
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	location := res.Header.Get("Location")
+	if order, ok := ol.orders[req.URL.String()]; ok {
+		delete(ol.orders, req.URL.String())
+		if location == "" {
+			res.Header.Set("Location", order)
+		}
+		return res, nil
+	}
+
+	// a new order comes back with its URL in the Location header and its finalize URL in the
+	// body. other responses with a Location header, like new accounts, have no finalize URL
+	if location == "" || res.StatusCode != http.StatusCreated {
+		return res, nil
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	var order struct {
+		Finalize string `json:"finalize"`
+	}
+	if json.Unmarshal(body, &order) == nil && order.Finalize != "" {
+		ol.orders[order.Finalize] = location
+	}
+	return res, nil
+}
+
+// wrap the manager's GetCertificate so that failures are logged. otherwise all we would see is
+// a TLS handshake error, which doesn't say what the ACME server didn't like
+func (app *application) acmeCertificate(m *autocert.Manager) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := m.GetCertificate(hello)
+		if err != nil {
+			app.logger.Warn("getting ACME certificate", "server_name", hello.ServerName, "error", err)
+		}
+		return cert, err
+	}
+}
+
+// the routes for the plain HTTP listener. with ACME it answers HTTP-01 challenges, and every
+// other request is redirected to the HTTPS listener at httpsAddr
+func (app *application) httpRoutes(m *autocert.Manager, httpsAddr string) http.Handler {
+	var handler http.Handler = app.redirectToHTTPS(httpsAddr)
+	if m != nil {
+		handler = m.HTTPHandler(handler)
+	}
+	return alice.New(requestID, app.realIP, app.logRequest).Then(handler)
+}
+
+// handler which sends requests to the same host and path on the HTTPS listener at httpsAddr.
+// only GET and HEAD are redirected. anything else has already sent its body in the clear, and
+// following the redirect would send it again, so it is refused instead
+func (app *application) redirectToHTTPS(httpsAddr string) http.Handler {
+	_, port, err := net.SplitHostPort(httpsAddr)
+	if err != nil || port == "443" {
+		port = ""
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		// the host without its port, and without the brackets around an IPv6 address
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = strings.TrimSuffix(strings.TrimPrefix(r.Host, "["), "]")
+		}
+		if host == "" {
+			app.clientError(w, http.StatusBadRequest)
+			return
+		}
+
+		switch {
+		case port != "":
+			host = net.JoinHostPort(host, port)
+		case strings.Contains(host, ":"):
+			host = "[" + host + "]"
+		}
+
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/config"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	tests := []struct {
+		name         string
+		httpsAddr    string
+		method       string
+		host         string
+		url          string
+		wantCode     int
+		wantLocation string
+	}{
+		{
+			name:         "Default port",
+			httpsAddr:    ":443",
+			method:       http.MethodGet,
+			host:         "snippetbox.example.com",
+			url:          "/snippet/view/1?x=y",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://snippetbox.example.com/snippet/view/1?x=y",
+		},
+		{
+			name:         "Other port",
+			httpsAddr:    ":4000",
+			method:       http.MethodHead,
+			host:         "localhost:8080",
+			url:          "/",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://localhost:4000/",
+		},
+		{
+			name:         "IPv6",
+			httpsAddr:    "[::1]:443",
+			method:       http.MethodGet,
+			host:         "[::1]:80",
+			url:          "/",
+			wantCode:     http.StatusMovedPermanently,
+			wantLocation: "https://[::1]/",
+		},
+		{
+			name:      "POST",
+			httpsAddr: ":443",
+			method:    http.MethodPost,
+			host:      "snippetbox.example.com",
+			url:       "/user/login",
+			wantCode:  http.StatusBadRequest,
+		},
+		{
+			name:      "No host",
+			httpsAddr: ":443",
+			method:    http.MethodGet,
+			host:      "",
+			url:       "/",
+			wantCode:  http.StatusBadRequest,
+		},
+	}
+
+	app := newTestApplication(t)
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.url, nil)
+			r.Host = tt.host
+			rr := httptest.NewRecorder()
+
+			app.redirectToHTTPS(tt.httpsAddr).ServeHTTP(rr, r)
+
+			assert.Equal(t, rr.Code, tt.wantCode)
+			assert.Equal(t, rr.Header().Get("Location"), tt.wantLocation)
+		})
+	}
+}
+
+func TestHTTPRoutesACME(t *testing.T) {
+	app := newTestApplication(t)
+
+	cfg := config.Default()
+	cfg.ACMEDomains = "snippetbox.example.com"
+	cfg.ACMECache = t.TempDir()
+
+	m, err := newACMEManager(&cfg)
+	assert.NilError(t, err)
+
+	// only the configured domains get certificates
+	assert.NilError(t, m.HostPolicy(context.Background(), "snippetbox.example.com"))
+	assert.Equal(t, m.HostPolicy(context.Background(), "evil.example.com") != nil, true)
+
+	handler := app.httpRoutes(m, ":443")
+
+	get := func(host, path string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, path, nil)
+		r.Host = host
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, r)
+		return rr
+	}
+
+	// challenges are answered by the manager. there's no challenge in progress for this token,
+	// so it isn't found, and challenges for other domains are refused
+	assert.Equal(t, get("snippetbox.example.com", "/.well-known/acme-challenge/abc").Code, http.StatusNotFound)
+	assert.Equal(t, get("evil.example.com", "/.well-known/acme-challenge/abc").Code, http.StatusForbidden)
+
+	// and everything else is redirected
+	rr := get("snippetbox.example.com", "/about")
+	assert.Equal(t, rr.Code, http.StatusMovedPermanently)
+	assert.Equal(t, rr.Header().Get("Location"), "https://snippetbox.example.com/about")
+}
+
+func TestNewACMEManagerDirectoryCA(t *testing.T) {
+	cfg := config.Default()
+	cfg.ACMEDomains = "snippetbox.example.com"
+
+	// the CA file has to exist and hold at least one certificate
+	cfg.ACMEDirectoryCA = filepath.Join(t.TempDir(), "missing.pem")
+	_, err := newACMEManager(&cfg)
+	assert.Equal(t, err != nil, true)
+
+	cfg.ACMEDirectoryCA = filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(cfg.ACMEDirectoryCA, []byte("not a certificate"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = newACMEManager(&cfg)
+	assert.Equal(t, err != nil && strings.Contains(err.Error(), "no certificates found"), true)
+
+	// any certificate will do as a CA
+	certPEM, _ := makeCertificate(t, "ca.example.com")
+	cfg.ACMEDirectoryCA = filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(cfg.ACMEDirectoryCA, certPEM, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	m, err := newACMEManager(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := m.Client.HTTPClient.Transport.(*orderLocator).next.(*http.Transport)
+	assert.Equal(t, transport.TLSClientConfig.RootCAs != nil, true)
+}
+
+func TestOrderLocator(t *testing.T) {
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/new-order":
+			w.Header().Set("Location", ts.URL+"/order/1")
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprintf(w, `{"status": "pending", "finalize": "%s/finalize/1"}`, ts.URL)
+		case "/finalize/1", "/finalize/2":
+			// like pebble, no Location header
+			fmt.Fprint(w, `{"status": "processing"}`)
+		}
+	}))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &orderLocator{next: http.DefaultTransport, orders: map[string]string{}}}
+	post := func(path string) *http.Response {
+		res, err := client.Post(ts.URL+path, "application/jose+json", nil)
+		assert.NilError(t, err)
+		res.Body.Close()
+		return res
+	}
+
+	res := post("/new-order")
+	assert.Equal(t, res.Header.Get("Location"), ts.URL+"/order/1")
+
+	// the finalize response gets the URL of its order
+	res = post("/finalize/1")
+	assert.Equal(t, res.Header.Get("Location"), ts.URL+"/order/1")
+
+	// but not of an order we haven't seen
+	res = post("/finalize/2")
+	assert.Equal(t, res.Header.Get("Location"), "")
+}
@@ -13,3 +13,12 @@ func Equal[T comparable](t *testing.T, actual, expected T) {
 		t.Errorf("got: %v; want: %v", actual, expected)
 	}
 }
+
+// func to test that an error is nil
+func NilError(t *testing.T, actual error) {
+	t.Helper()
+
+	if actual != nil {
+		t.Errorf("got: %v; expected: nil", actual)
+	}
+}
@@ -0,0 +1,315 @@
+package dialect
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+
+	// the sqlite driver registers itself with database/sql under the name "sqlite"
+	_ "modernc.org/sqlite"
+)
+
+var (
+	// error if Get() is asked for a database driver that we don't support
+	ErrUnknownDriver = errors.New("dialect: unknown database driver")
+
+	// error if a named lock couldn't be taken before the timeout
+	ErrLockTimeout = errors.New("dialect: timed out waiting for lock")
+)
+
+// the parts of our SQL which differ between the databases we support. the models write
+// their queries with ? placeholders and ask the dialect to fill in the rest.
+type Dialect interface {
+	// the name of the driver to pass to sql.Open(), and of the -db-driver flag value
+	Name() string
+
+	// rewrite the ? placeholders in query into the form the driver expects
+	Rebind(query string) string
+
+	// SQL expression for the current UTC time
+	Now() string
+
+	// SQL expression for the current UTC time plus a number of units, taken from a
+	// single ? placeholder. unit is one of "DAY", "HOUR", "MINUTE" or "SECOND"
+	NowPlus(unit string) string
+
+	// SQL expression for the current time which can be compared with the expiry column
+	// of the scs session store's sessions table
+	SessionNow() string
+
+	// run an INSERT statement and return the id of the new row
+	Insert(q Querier, stmt string, args ...any) (int, error)
+
+	// report whether err is a violation of the named unique constraint. column is the
+	// table-qualified column it covers (like "users.email"), for databases whose errors
+	// don't include the constraint name
+	IsUniqueViolation(err error, constraint, column string) bool
+
+	// SQL condition which is true when the text in columns matches a search query taken
+	// from a single ? placeholder
+	TextMatch(columns ...string) string
+
+	// SQL expression which ranks how well the text in columns matches a search query taken
+	// from a single ? placeholder. higher is better
+	TextRank(columns ...string) string
+
+	// take and release a named lock which is held across processes, for as long as conn
+	// stays open. used to stop two processes running migrations at the same time
+	Lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error
+	Unlock(ctx context.Context, conn *sql.Conn, name string) error
+}
+
+// Querier is satisfied by both *sql.DB and *sql.Tx
+type Querier interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// the dialects we support
+var (
+	MySQL    Dialect = mysqlDialect{}
+	Postgres Dialect = postgresDialect{}
+	SQLite   Dialect = sqliteDialect{}
+)
+
+// return the dialect for the -db-driver flag value name
+func Get(name string) (Dialect, error) {
+	switch name {
+	case "mysql":
+		return MySQL, nil
+	case "postgres":
+		return Postgres, nil
+	case "sqlite":
+		return SQLite, nil
+	}
+	return nil, fmt.Errorf("%w: %q", ErrUnknownDriver, name)
+}
+
+// open a connection pool for d and check that it works
+func Open(d Dialect, dsn string) (*sql.DB, error) {
+	// sqlite leaves foreign key checks off unless they are turned on for each connection,
+	// and fails straight away when the database is busy unless given a timeout. we also ask
+	// the driver to write times in a format that sqlite's date functions understand
+	if d == SQLite && !strings.Contains(dsn, "_pragma=") {
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		dsn += sep + "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_time_format=sqlite"
+	}
+
+	db, err := sql.Open(d.Name(), dsn)
+	if err != nil {
+		return nil, err
+	}
+
+	// sqlite allows a single writer, so we stick to one connection to avoid "database is locked"
+	// errors. this also means an in-memory database is shared by everything using the pool
+	if d == SQLite {
+		db.SetMaxOpenConns(1)
+	}
+
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// return d, or MySQL if d is nil. the models fall back to MySQL when no dialect is set
+func OrDefault(d Dialect) Dialect {
+	if d == nil {
+		return MySQL
+	}
+	return d
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string { return "mysql" }
+
+func (mysqlDialect) Rebind(query string) string { return query }
+
+func (mysqlDialect) Now() string { return "UTC_TIMESTAMP()" }
+
+func (mysqlDialect) SessionNow() string { return "UTC_TIMESTAMP(6)" }
+
+func (mysqlDialect) NowPlus(unit string) string {
+	return fmt.Sprintf("DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? %s)", unit)
+}
+
+func (mysqlDialect) Insert(q Querier, stmt string, args ...any) (int, error) {
+	result, err := q.Exec(stmt, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// check LastInsertId() to get the ID of the newly inserted record
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func (mysqlDialect) IsUniqueViolation(err error, constraint, column string) bool {
+	// the error code for a duplicate entry is 1062, and the message names the key
+	var mySQLError *mysql.MySQLError
+	if errors.As(err, &mySQLError) {
+		return mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, constraint)
+	}
+	return false
+}
+
+func (mysqlDialect) TextMatch(columns ...string) string {
+	return fmt.Sprintf("MATCH(%s) AGAINST(? IN NATURAL LANGUAGE MODE)", strings.Join(columns, ", "))
+}
+
+func (d mysqlDialect) TextRank(columns ...string) string {
+	// MATCH() returns the relevance score when used outside of a WHERE clause
+	return d.TextMatch(columns...)
+}
+
+func (mysqlDialect) Lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error {
+	var got sql.NullInt64
+	err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", name, int(timeout.Seconds())).Scan(&got)
+	if err != nil {
+		return err
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return ErrLockTimeout
+	}
+	return nil
+}
+
+func (mysqlDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", name)
+	return err
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string { return "postgres" }
+
+// postgres numbers its placeholders, so ? becomes $1, $2 and so on
+func (postgresDialect) Rebind(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (postgresDialect) Now() string { return "(NOW() AT TIME ZONE 'UTC')" }
+
+func (postgresDialect) SessionNow() string { return "current_timestamp" }
+
+func (postgresDialect) NowPlus(unit string) string {
+	arg := map[string]string{"DAY": "days", "HOUR": "hours", "MINUTE": "mins", "SECOND": "secs"}[unit]
+	return fmt.Sprintf("((NOW() AT TIME ZONE 'UTC') + make_interval(%s => ?))", arg)
+}
+
+// postgres doesn't support LastInsertId(), so we ask for the id back with RETURNING instead
+func (d postgresDialect) Insert(q Querier, stmt string, args ...any) (int, error) {
+	stmt = strings.TrimRight(strings.TrimSpace(stmt), ";") + " RETURNING id"
+
+	var id int
+	err := q.QueryRow(stmt, args...).Scan(&id)
+	return id, err
+}
+
+func (postgresDialect) IsUniqueViolation(err error, constraint, column string) bool {
+	var pqError *pq.Error
+	if errors.As(err, &pqError) {
+		return pqError.Code == "23505" && pqError.Constraint == constraint
+	}
+	return false
+}
+
+func (postgresDialect) document(columns []string) string {
+	return fmt.Sprintf("to_tsvector('english', %s)", strings.Join(columns, " || ' ' || "))
+}
+
+func (d postgresDialect) TextMatch(columns ...string) string {
+	return d.document(columns) + " @@ plainto_tsquery('english', ?)"
+}
+
+func (d postgresDialect) TextRank(columns ...string) string {
+	return fmt.Sprintf("ts_rank(%s, plainto_tsquery('english', ?))", d.document(columns))
+}
+
+func (postgresDialect) Lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1))", name)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrLockTimeout
+	}
+	return err
+}
+
+func (postgresDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	_, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1))", name)
+	return err
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string { return "sqlite" }
+
+func (sqliteDialect) Rebind(query string) string { return query }
+
+// datetime() gives us text like "2006-01-02 15:04:05", which sorts and compares correctly
+// as long as every time in the database is written the same way
+func (sqliteDialect) Now() string { return "datetime('now')" }
+
+// the sqlite session store keeps expiry times as julian day numbers
+func (sqliteDialect) SessionNow() string { return "julianday('now')" }
+
+func (sqliteDialect) NowPlus(unit string) string {
+	return fmt.Sprintf("datetime('now', ? || ' %s')", strings.ToLower(unit)+"s")
+}
+
+func (sqliteDialect) Insert(q Querier, stmt string, args ...any) (int, error) {
+	return mysqlDialect{}.Insert(q, stmt, args...)
+}
+
+// sqlite error messages name the columns rather than the constraint,
+// like "UNIQUE constraint failed: users.email"
+func (sqliteDialect) IsUniqueViolation(err error, constraint, column string) bool {
+	return err != nil && strings.Contains(err.Error(), "UNIQUE constraint failed: "+column)
+}
+
+// sqlite has no full-text search without a separate FTS table, so we fall back to a
+// case-insensitive substring match. that's fine for the small databases used in development
+func (sqliteDialect) TextMatch(columns ...string) string {
+	return fmt.Sprintf("instr(lower(%s), lower(?)) > 0", strings.Join(columns, " || ' ' || "))
+}
+
+// rank matches in the first column (the title) above matches elsewhere
+func (sqliteDialect) TextRank(columns ...string) string {
+	return fmt.Sprintf("instr(lower(%s), lower(?)) > 0", columns[0])
+}
+
+// sqlite only allows one writer at a time anyway, so there is nothing to lock
+func (sqliteDialect) Lock(ctx context.Context, conn *sql.Conn, name string, timeout time.Duration) error {
+	return nil
+}
+
+func (sqliteDialect) Unlock(ctx context.Context, conn *sql.Conn, name string) error {
+	return nil
+}
@@ -0,0 +1,145 @@
+package mailer
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// a single email, ready to be handed to a Transport
+type Message struct {
+	From      string
+	To        string
+	Subject   string
+	PlainBody string
+	HTMLBody  string
+}
+
+// a Transport delivers messages. SMTPTransport sends them for real, while FileOutbox and
+// MemoryOutbox keep them locally for development and tests
+type Transport interface {
+	Send(msg *Message) error
+}
+
+// define a Mailer type which renders emails from templates and sends them with a Transport
+type Mailer struct {
+	transport Transport
+	sender    string
+	templates fs.FS
+}
+
+// create a new Mailer. sender is the From address, like "Snippetbox <no-reply@example.com>",
+// and templates holds the email templates (see Send())
+func New(transport Transport, sender string, templates fs.FS) *Mailer {
+	return &Mailer{transport: transport, sender: sender, templates: templates}
+}
+
+// render the email in templateFile with data and send it to recipient. the template file
+// must define three named templates: "subject", "plainBody" and "htmlBody". the HTML body is
+// rendered with html/template so that data is escaped, and the others with text/template
+func (m *Mailer) Send(recipient, templateFile string, data any) error {
+	tmpl, err := template.New("email").ParseFS(m.templates, templateFile)
+	if err != nil {
+		return err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return err
+	}
+
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(m.templates, templateFile)
+	if err != nil {
+		return err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return err
+	}
+
+	msg := &Message{
+		From:      m.sender,
+		To:        recipient,
+		Subject:   strings.TrimSpace(subject.String()),
+		PlainBody: plainBody.String(),
+		HTMLBody:  htmlBody.String(),
+	}
+	return m.transport.Send(msg)
+}
+
+// encode the message in the MIME format which is sent over SMTP, with the plain-text
+// and HTML bodies as alternatives
+func (msg *Message) Bytes() ([]byte, error) {
+	buf := new(bytes.Buffer)
+	mw := multipart.NewWriter(buf)
+
+	headers := []struct{ key, value string }{
+		{"From", msg.From},
+		{"To", msg.To},
+		{"Subject", mime.QEncoding.Encode("utf-8", msg.Subject)},
+		{"Date", time.Now().Format(time.RFC1123Z)},
+		{"Message-ID", messageID(msg.From)},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + mw.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(buf, "%s: %s\r\n", h.key, h.value)
+	}
+	buf.WriteString("\r\n")
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.PlainBody},
+		{"text/html; charset=utf-8", msg.HTMLBody},
+	} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qw := quotedprintable.NewWriter(pw)
+		if _, err := qw.Write([]byte(part.body)); err != nil {
+			return nil, err
+		}
+		if err := qw.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// return a unique Message-ID header value, using the domain of the sender's address
+func messageID(from string) string {
+	domain := "localhost"
+	if addr, err := mail.ParseAddress(from); err == nil {
+		if _, d, ok := strings.Cut(addr.Address, "@"); ok {
+			domain = d
+		}
+	}
+
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
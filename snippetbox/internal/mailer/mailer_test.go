@@ -0,0 +1,200 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+var testTemplates = fstest.MapFS{
+	"welcome.tmpl": {Data: []byte(`
+{{define "subject"}}Welcome, {{.Name}}{{end}}
+{{define "plainBody"}}Hi {{.Name}}, thanks for joining.{{end}}
+{{define "htmlBody"}}<p>Hi {{.Name}}, thanks for joining.</p>{{end}}
+`)},
+}
+
+func TestSend(t *testing.T) {
+	outbox := &MemoryOutbox{}
+	m := New(outbox, "Snippetbox <no-reply@example.com>", testTemplates)
+
+	err := m.Send("alice@example.com", "welcome.tmpl", map[string]string{"Name": "<Alice>"})
+	assert.NilError(t, err)
+
+	messages := outbox.Messages()
+	assert.Equal(t, len(messages), 1)
+	assert.Equal(t, messages[0].From, "Snippetbox <no-reply@example.com>")
+	assert.Equal(t, messages[0].To, "alice@example.com")
+	assert.Equal(t, messages[0].Subject, "Welcome, <Alice>")
+	assert.Equal(t, messages[0].PlainBody, "Hi <Alice>, thanks for joining.")
+
+	// only the HTML body is escaped
+	assert.Equal(t, messages[0].HTMLBody, "<p>Hi &lt;Alice&gt;, thanks for joining.</p>")
+
+	err = m.Send("alice@example.com", "missing.tmpl", nil)
+	assert.Equal(t, err != nil, true)
+}
+
+// parse an encoded message and return its headers and the decoded plain-text and HTML parts
+func parseMessage(t *testing.T, raw []byte) (mail.Header, string, string) {
+	t.Helper()
+
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, mediaType, "multipart/alternative")
+
+	parts := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[p.Header.Get("Content-Type")] = string(body)
+	}
+	return msg.Header, parts["text/plain; charset=utf-8"], parts["text/html; charset=utf-8"]
+}
+
+func TestMessageBytes(t *testing.T) {
+	msg := &Message{
+		From:      "Snippetbox <no-reply@example.com>",
+		To:        "alice@example.com",
+		Subject:   "Café = ☕",
+		PlainBody: "A long line which is well over seventy six characters, so quoted-printable has to wrap it.",
+		HTMLBody:  "<p>Hello</p>",
+	}
+
+	raw, err := msg.Bytes()
+	assert.NilError(t, err)
+
+	header, plain, html := parseMessage(t, raw)
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(header.Get("Subject"))
+	assert.NilError(t, err)
+	assert.Equal(t, subject, msg.Subject)
+	assert.Equal(t, header.Get("To"), msg.To)
+	assert.Equal(t, strings.HasSuffix(header.Get("Message-ID"), "@example.com>"), true)
+	assert.Equal(t, plain, msg.PlainBody)
+	assert.Equal(t, html, msg.HTMLBody)
+}
+
+func TestFileOutbox(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox")
+	outbox := &FileOutbox{Dir: dir}
+
+	for i := 0; i < 2; i++ {
+		err := outbox.Send(&Message{From: "a@example.com", To: "b@example.com", Subject: "hi", PlainBody: "hello"})
+		assert.NilError(t, err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	assert.NilError(t, err)
+	assert.Equal(t, len(files), 2)
+
+	raw, err := os.ReadFile(files[0])
+	assert.NilError(t, err)
+	_, plain, _ := parseMessage(t, raw)
+	assert.Equal(t, plain, "hello")
+}
+
+// a minimal SMTP server which accepts a single message and sends what it received on the
+// returned channel. it doesn't offer STARTTLS or AUTH
+func fakeSMTPServer(t *testing.T) (string, <-chan string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	received := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		var transcript strings.Builder
+		r := bufio.NewReader(conn)
+		reply := func(s string) { io.WriteString(conn, s+"\r\n") }
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			transcript.WriteString(line)
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250 localhost")
+			case cmd == "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					transcript.WriteString(line)
+					if line == ".\r\n" {
+						break
+					}
+				}
+				reply("250 ok")
+			case cmd == "QUIT":
+				reply("221 bye")
+				received <- transcript.String()
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	return ln.Addr().String(), received
+}
+
+func TestSMTPTransport(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	host, portStr, _ := net.SplitHostPort(addr)
+	port, err := strconv.Atoi(portStr)
+	assert.NilError(t, err)
+
+	transport := &SMTPTransport{Host: host, Port: port}
+
+	m := New(transport, "Snippetbox <no-reply@example.com>", testTemplates)
+	err = m.Send("Alice <alice@example.com>", "welcome.tmpl", map[string]string{"Name": "Alice"})
+	assert.NilError(t, err)
+
+	transcript := <-received
+	assert.Equal(t, strings.Contains(transcript, "MAIL FROM:<no-reply@example.com>"), true)
+	assert.Equal(t, strings.Contains(transcript, "RCPT TO:<alice@example.com>"), true)
+	assert.Equal(t, strings.Contains(transcript, "Subject: Welcome, Alice"), true)
+}
@@ -0,0 +1,139 @@
+package mailer
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SMTPTransport sends messages through an SMTP server. the connection is upgraded with
+// STARTTLS when the server supports it, and we log in when a Username is set
+type SMTPTransport struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	Timeout  time.Duration // for the whole conversation with the server. defaults to 10 seconds
+}
+
+func (t *SMTPTransport) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	// the SMTP envelope wants bare addresses, without the display names
+	from, err := mail.ParseAddress(msg.From)
+	if err != nil {
+		return fmt.Errorf("mailer: sender: %w", err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("mailer: recipient: %w", err)
+	}
+
+	timeout := t.Timeout
+	if timeout == 0 {
+		timeout = 10 * time.Second
+	}
+
+	// net/smtp has no timeouts of its own, so we dial the connection ourselves
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(t.Host, strconv.Itoa(t.Port)), timeout)
+	if err != nil {
+		return err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, t.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok {
+		if err := c.StartTLS(&tls.Config{ServerName: t.Host}); err != nil {
+			return err
+		}
+	}
+	if t.Username != "" {
+		// PlainAuth refuses to send the password over an unencrypted connection, except to localhost
+		if err := c.Auth(smtp.PlainAuth("", t.Username, t.Password, t.Host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(from.Address); err != nil {
+		return err
+	}
+	if err := c.Rcpt(to.Address); err != nil {
+		return err
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// FileOutbox writes each message to a .eml file in Dir instead of sending it. the files
+// can be opened with most mail clients, which makes it handy for development
+type FileOutbox struct {
+	Dir string
+
+	// counts the messages written, so that two messages sent in the same instant
+	// get different file names
+	count atomic.Int64
+}
+
+func (o *FileOutbox) Send(msg *Message) error {
+	body, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(o.Dir, 0o755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%04d.eml", time.Now().UTC().Format("20060102T150405.000000000"), o.count.Add(1))
+	return os.WriteFile(filepath.Join(o.Dir, name), body, 0o600)
+}
+
+// MemoryOutbox keeps messages in memory instead of sending them. it is safe for concurrent
+// use, and is meant for tests
+type MemoryOutbox struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+func (o *MemoryOutbox) Send(msg *Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, *msg)
+	return nil
+}
+
+// return a copy of every message sent so far, oldest first
+func (o *MemoryOutbox) Messages() []Message {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	return append([]Message(nil), o.messages...)
+}
@@ -0,0 +1,341 @@
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+var (
+	// error if the database was left part way through a migration. the schema has to be
+	// fixed by hand and the version set with Force() before migrating again
+	ErrDirty = errors.New("migrate: database is dirty, fix the schema and force a version")
+
+	// error if the version recorded in the database has no matching migration files
+	ErrUnknownVersion = errors.New("migrate: unknown version")
+
+	// error if another process holds the migration lock for longer than LockTimeout
+	ErrLocked = dialect.ErrLockTimeout
+)
+
+// how long to wait for another process to finish migrating before giving up
+const LockTimeout = 30 * time.Second
+
+// name of the lock which is held while migrations run, so that several
+// instances of the app starting at once don't race each other
+const lockName = "snippetbox_migrate"
+
+// migration files are named like 0001_create_users_table.up.sql
+var fileRx = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// a single schema change, with the SQL to apply and to revert it
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// the state of a single migration, as reported by Status()
+type Status struct {
+	Migration
+	Applied bool
+}
+
+// define a Migrator type which applies migrations from a filesystem to a sql.DB connection pool.
+// the current version is kept in a schema_migrations table, along with a dirty flag
+// which is set while a migration is running
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    dialect.Dialect
+	Migrations []Migration // sorted by version
+}
+
+// create a new Migrator for the migrations in the root of files (for example the result
+// of migrations.For())
+func New(db *sql.DB, d dialect.Dialect, files fs.FS) (*Migrator, error) {
+	migrations, err := Parse(files)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Dialect: d, Migrations: migrations}, nil
+}
+
+// read every migration file in the root of files and pair them up by version
+func Parse(files fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(files, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		match := fileRx.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.Atoi(match[1])
+		if err != nil {
+			return nil, err
+		}
+
+		body, err := fs.ReadFile(files, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migrate: version %d has two names, %q and %q", version, m.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migrate: version %d needs both an up and a down file", m.Version)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// apply every pending migration, in order. returns the number of migrations applied.
+func (m *Migrator) Up() (int, error) {
+	var applied int
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := m.current(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.Migrations {
+			if mig.Version <= current {
+				continue
+			}
+			if err := m.run(conn, mig.Version, mig.Up, mig.Version); err != nil {
+				return fmt.Errorf("migrate: applying %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			applied++
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// revert the most recently applied migrations, up to steps of them. returns the number
+// of migrations reverted.
+func (m *Migrator) Down(steps int) (int, error) {
+	var reverted int
+	err := m.withLock(func(conn *sql.Conn) error {
+		current, err := m.current(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.Migrations) - 1; i >= 0 && reverted < steps; i-- {
+			mig := m.Migrations[i]
+			if mig.Version > current {
+				continue
+			}
+
+			// after reverting we are at the version of the previous migration, or zero
+			previous := 0
+			if i > 0 {
+				previous = m.Migrations[i-1].Version
+			}
+			if err := m.run(conn, mig.Version, mig.Down, previous); err != nil {
+				return fmt.Errorf("migrate: reverting %04d_%s: %w", mig.Version, mig.Name, err)
+			}
+			reverted++
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// report every known migration and whether it has been applied, along with the
+// version recorded in the database and whether it is dirty
+func (m *Migrator) Status() ([]Status, int, bool, error) {
+	conn, err := m.DB.Conn(context.Background())
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer conn.Close()
+
+	if err := m.ensureTable(conn); err != nil {
+		return nil, 0, false, err
+	}
+	version, dirty, err := m.version(conn)
+	if err != nil {
+		return nil, 0, false, err
+	}
+
+	statuses := make([]Status, len(m.Migrations))
+	for i, mig := range m.Migrations {
+		statuses[i] = Status{Migration: mig, Applied: mig.Version <= version}
+	}
+	return statuses, version, dirty, nil
+}
+
+// set the recorded version and clear the dirty flag without running any SQL. this is
+// used to recover after a failed migration has been fixed by hand. a version of zero
+// means no migrations are applied.
+func (m *Migrator) Force(version int) error {
+	if version != 0 && m.find(version) == nil {
+		return ErrUnknownVersion
+	}
+	return m.withLock(func(conn *sql.Conn) error {
+		return m.setVersion(conn, version, false)
+	})
+}
+
+// return the migration with the given version, or nil if there isn't one
+func (m *Migrator) find(version int) *Migration {
+	for i := range m.Migrations {
+		if m.Migrations[i].Version == version {
+			return &m.Migrations[i]
+		}
+	}
+	return nil
+}
+
+// run the statements in body, marking the database dirty at version while they run and
+// recording the target version once they have all succeeded. MySQL commits DDL statements
+// implicitly, so we don't use a transaction. a failure part way through leaves the dirty
+// flag set for a human to look at
+func (m *Migrator) run(conn *sql.Conn, version int, body string, target int) error {
+	if err := m.setVersion(conn, version, true); err != nil {
+		return err
+	}
+	for _, stmt := range splitStatements(body) {
+		if _, err := conn.ExecContext(context.Background(), stmt); err != nil {
+			return err
+		}
+	}
+	return m.setVersion(conn, target, false)
+}
+
+// take the migration lock on a dedicated connection, make sure the schema_migrations
+// table exists and then call fn. the lock is released when fn returns
+func (m *Migrator) withLock(fn func(conn *sql.Conn) error) error {
+	ctx := context.Background()
+
+	// named locks belong to a connection, so everything has to happen on the same one
+	conn, err := m.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	d := dialect.OrDefault(m.Dialect)
+	if err := d.Lock(ctx, conn, lockName, LockTimeout); err != nil {
+		return err
+	}
+	defer d.Unlock(ctx, conn, lockName)
+
+	if err := m.ensureTable(conn); err != nil {
+		return err
+	}
+	return fn(conn)
+}
+
+// return the current version, refusing to carry on if the database is dirty
+func (m *Migrator) current(conn *sql.Conn) (int, error) {
+	version, dirty, err := m.version(conn)
+	if err != nil {
+		return 0, err
+	}
+	if dirty {
+		return 0, ErrDirty
+	}
+	if version != 0 && m.find(version) == nil {
+		return 0, ErrUnknownVersion
+	}
+	return version, nil
+}
+
+func (m *Migrator) ensureTable(conn *sql.Conn) error {
+	stmt := `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version BIGINT NOT NULL PRIMARY KEY,
+			dirty BOOLEAN NOT NULL
+		);
+	`
+	_, err := conn.ExecContext(context.Background(), stmt)
+	return err
+}
+
+// read the version recorded in the schema_migrations table. an empty table means version zero
+func (m *Migrator) version(conn *sql.Conn) (int, bool, error) {
+	var version int
+	var dirty bool
+
+	err := conn.QueryRowContext(context.Background(), "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, false, nil
+		}
+		return 0, false, err
+	}
+	return version, dirty, nil
+}
+
+// replace the single row in the schema_migrations table
+func (m *Migrator) setVersion(conn *sql.Conn, version int, dirty bool) error {
+	ctx := context.Background()
+	if _, err := conn.ExecContext(ctx, "DELETE FROM schema_migrations"); err != nil {
+		return err
+	}
+	stmt := dialect.OrDefault(m.Dialect).Rebind("INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)")
+	_, err := conn.ExecContext(ctx, stmt, version, dirty)
+	return err
+}
+
+// split the body of a migration file into individual statements. the mysql driver only
+// runs one statement per Exec() unless multiStatements is turned on in the DSN, so we
+// send them one at a time for every dialect. statements must end with a semicolon at the end of a line
+func splitStatements(body string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(body, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), "--") {
+			continue
+		}
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			if stmt := strings.TrimSpace(current.String()); stmt != ";" {
+				stmts = append(stmts, stmt)
+			}
+			current.Reset()
+		}
+	}
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		stmts = append(stmts, stmt)
+	}
+	return stmts
+}
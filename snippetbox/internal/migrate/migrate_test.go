@@ -0,0 +1,84 @@
+package migrate
+
+import (
+	"testing"
+	"testing/fstest"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/dialect"
+	"snippetbox.lets-go/migrations"
+)
+
+func TestParse(t *testing.T) {
+	files := fstest.MapFS{
+		"0002_second.up.sql":   {Data: []byte("CREATE TABLE b (id INT);")},
+		"0002_second.down.sql": {Data: []byte("DROP TABLE b;")},
+		"0001_first.up.sql":    {Data: []byte("CREATE TABLE a (id INT);")},
+		"0001_first.down.sql":  {Data: []byte("DROP TABLE a;")},
+		"README.md":            {Data: []byte("ignored")},
+	}
+
+	migrations, err := Parse(files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assert.Equal(t, len(migrations), 2)
+	assert.Equal(t, migrations[0].Version, 1)
+	assert.Equal(t, migrations[0].Name, "first")
+	assert.Equal(t, migrations[1].Version, 2)
+	assert.Equal(t, migrations[1].Down, "DROP TABLE b;")
+}
+
+func TestParseMissingDown(t *testing.T) {
+	files := fstest.MapFS{
+		"0001_first.up.sql": {Data: []byte("CREATE TABLE a (id INT);")},
+	}
+
+	_, err := Parse(files)
+	assert.Equal(t, err != nil, true)
+}
+
+// make sure the embedded migrations that ship with the app are well formed, and that every
+// dialect has the same set of them
+func TestEmbeddedMigrations(t *testing.T) {
+	var names []string
+	for _, d := range []dialect.Dialect{dialect.MySQL, dialect.Postgres, dialect.SQLite} {
+		t.Run(d.Name(), func(t *testing.T) {
+			files, err := migrations.For(d)
+			if err != nil {
+				t.Fatal(err)
+			}
+			m, err := Parse(files)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names == nil {
+				for i := range m {
+					names = append(names, m[i].Name)
+				}
+			}
+
+			assert.Equal(t, len(m), len(names))
+			for i := range m {
+				assert.Equal(t, m[i].Version, i+1)
+				assert.Equal(t, m[i].Name, names[i])
+			}
+		})
+	}
+}
+
+func TestSplitStatements(t *testing.T) {
+	body := `-- a comment
+CREATE TABLE a (
+    id INT
+);
+
+CREATE INDEX idx ON a (id);
+`
+	stmts := splitStatements(body)
+
+	assert.Equal(t, len(stmts), 2)
+	assert.Equal(t, stmts[0], "CREATE TABLE a (\n    id INT\n);")
+	assert.Equal(t, stmts[1], "CREATE INDEX idx ON a (id);")
+}
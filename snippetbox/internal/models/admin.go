@@ -0,0 +1,293 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// totals shown at the top of the admin dashboard
+type AdminStats struct {
+	Users         int
+	DisabledUsers int
+	Snippets      int // every snippet still in the database, including expired ones
+	LiveSnippets  int // snippets which haven't expired yet
+}
+
+// how many users signed up and how many snippets were created on one day (in UTC)
+type DailyCount struct {
+	Day      time.Time
+	Signups  int
+	Snippets int
+}
+
+// the methods that the admin pages need. unlike the rest of the app, admins get to see
+// every user and every snippet, including the ones which have expired
+type AdminModelInterface interface {
+	Stats() (*AdminStats, error)
+	DailyCounts(from, to time.Time) ([]DailyCount, error)
+	Users(query string, page int) ([]*User, bool, error)
+	Snippets(query string, page int) ([]*Snippet, bool, error)
+	SetUserDisabled(id int, disabled bool) error
+	ExpireSnippet(id int) error
+}
+
+// define an AdminModel type which wraps a sql.DB connection pool. the Dialect defaults
+// to MySQL when it isn't set.
+type AdminModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// return the totals for the dashboard
+func (m *AdminModel) Stats() (*AdminStats, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	s := &AdminStats{}
+	stmt := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM users WHERE disabled = ?),
+			(SELECT COUNT(*) FROM snippets),
+			(SELECT COUNT(*) FROM snippets WHERE expires > ` + d.Now() + `);
+	`
+	err := m.DB.QueryRow(d.Rebind(stmt), true).Scan(&s.Users, &s.DisabledUsers, &s.Snippets, &s.LiveSnippets)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// return the number of signups and new snippets for every day from from to to, oldest first.
+// only the dates of from and to matter, and days without any activity are still included
+// with zero counts, so that the result can be drawn as a chart without gaps
+func (m *AdminModel) DailyCounts(from, to time.Time) ([]DailyCount, error) {
+	from = day(from)
+	to = day(to)
+	if to.Before(from) {
+		return []DailyCount{}, nil
+	}
+
+	signups, err := m.countByDay("users", from)
+	if err != nil {
+		return nil, err
+	}
+	snippets, err := m.countByDay("snippets", from)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := []DailyCount{}
+	for t := from; !t.After(to); t = t.AddDate(0, 0, 1) {
+		key := t.Format("2006-01-02")
+		counts = append(counts, DailyCount{Day: t, Signups: signups[key], Snippets: snippets[key]})
+	}
+	return counts, nil
+}
+
+// count the rows in table created on each day since from, keyed by the date as YYYY-MM-DD
+func (m *AdminModel) countByDay(table string, from time.Time) (map[string]int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := `
+		SELECT
+			DATE(created), COUNT(*)
+		FROM
+			` + table + `
+		WHERE
+			created >= ?
+		GROUP BY
+			DATE(created);
+	`
+	rows, err := m.DB.Query(d.Rebind(stmt), from.Format("2006-01-02"))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[string]int{}
+	for rows.Next() {
+		// sqlite hands the date back as text and the other drivers as a time.Time, which
+		// database/sql turns into an RFC 3339 string. either way it starts with the date
+		var date string
+		var n int
+		if err := rows.Scan(&date, &n); err != nil {
+			return nil, err
+		}
+		if len(date) >= 10 {
+			counts[date[:10]] += n
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// return one page of users whose name or email address contains query, newest first. an
+// empty query matches everybody. pages are numbered from 1 and hold DefaultPageSize users,
+// and the bool return value reports whether there are more after this page
+func (m *AdminModel) Users(query string, page int) ([]*User, bool, error) {
+	d := dialect.OrDefault(m.Dialect)
+	if page < 1 {
+		page = 1
+	}
+	limit := DefaultPageSize
+
+	stmt := `
+		SELECT
+			id, name, email, created, activated, role, disabled
+		FROM
+			users
+		WHERE
+			LOWER(name) LIKE ? ESCAPE '!'
+			OR LOWER(email) LIKE ? ESCAPE '!'
+		ORDER BY
+			id DESC
+		LIMIT ? OFFSET ?;
+	`
+	pattern := likePattern(query)
+	rows, err := m.DB.Query(d.Rebind(stmt), pattern, pattern, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u := &User{}
+		err := rows.Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.Role, &u.Disabled)
+		if err != nil {
+			return nil, false, err
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	more := len(users) > limit
+	if more {
+		users = users[:limit]
+	}
+	return users, more, nil
+}
+
+// return one page of snippets whose title or author's name contains query, newest first,
+// in the same way as Users(). expired snippets which haven't been reaped yet are included
+func (m *AdminModel) Snippets(query string, page int) ([]*Snippet, bool, error) {
+	d := dialect.OrDefault(m.Dialect)
+	if page < 1 {
+		page = 1
+	}
+	limit := DefaultPageSize
+
+	stmt := `
+		SELECT
+			s.id,
+			s.user_id,
+			u.name,
+			s.title,
+			s.content,
+			s.created,
+			s.expires
+		FROM
+			snippets s
+			INNER JOIN users u ON u.id = s.user_id
+		WHERE
+			LOWER(s.title) LIKE ? ESCAPE '!'
+			OR LOWER(u.name) LIKE ? ESCAPE '!'
+		ORDER BY
+			s.id DESC
+		LIMIT ? OFFSET ?;
+	`
+	pattern := likePattern(query)
+	rows, err := m.DB.Query(d.Rebind(stmt), pattern, pattern, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, err := scanSnippets(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(snippets) > limit
+	if more {
+		snippets = snippets[:limit]
+	}
+	return snippets, more, nil
+}
+
+// disable or re-enable the user with the given id. disabled users can't log in, and are
+// treated as logged out by sessions and API tokens they already have. if there is no such
+// user we return ErrNoRecord
+func (m *AdminModel) SetUserDisabled(id int, disabled bool) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "UPDATE users SET disabled = ? WHERE id = ? AND disabled <> ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), disabled, id, disabled)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// like in UserModel.Activate(), nothing changing might just mean there was nothing to do
+	if n == 0 {
+		return m.exists("users", id)
+	}
+	return nil
+}
+
+// make the snippet with the given id expire straight away, so that it disappears from the
+// site and gets cleaned up by the reaper. a snippet which has already expired is left alone.
+// if there is no such snippet we return ErrNoRecord
+func (m *AdminModel) ExpireSnippet(id int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "UPDATE snippets SET expires = " + d.Now() + " WHERE id = ? AND expires > " + d.Now()
+	result, err := m.DB.Exec(d.Rebind(stmt), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return m.exists("snippets", id)
+	}
+	return nil
+}
+
+// return ErrNoRecord if table has no row with the given id
+func (m *AdminModel) exists(table string, id int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	var exists bool
+	stmt := "SELECT EXISTS(SELECT true FROM " + table + " WHERE id = ?)"
+	if err := m.DB.QueryRow(d.Rebind(stmt), id).Scan(&exists); err != nil {
+		return err
+	}
+	if !exists {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// return the midnight (UTC) at the start of t's day
+func day(t time.Time) time.Time {
+	y, mo, dd := t.UTC().Date()
+	return time.Date(y, mo, dd, 0, 0, 0, 0, time.UTC)
+}
+
+// turn a search query into a LIKE pattern which matches anything containing it, ignoring
+// case. the wildcard characters are escaped with '!' so that they match literally
+func likePattern(query string) string {
+	query = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(query)
+	return "%" + strings.ToLower(strings.TrimSpace(query)) + "%"
+}
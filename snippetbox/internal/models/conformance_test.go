@@ -0,0 +1,747 @@
+package models_test
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/dialect"
+	"snippetbox.lets-go/internal/migrate"
+	"snippetbox.lets-go/internal/models"
+	"snippetbox.lets-go/migrations"
+)
+
+// a database for the conformance suite to run against
+type backend struct {
+	dialect dialect.Dialect
+	dsn     string
+}
+
+// return the backends to test. sqlite always runs, using a file in a temporary directory.
+// mysql and postgres only run when a DSN for a disposable test database is given in the
+// SNIPPETBOX_TEST_MYSQL_DSN or SNIPPETBOX_TEST_POSTGRES_DSN environment variables
+func backends(t *testing.T) []backend {
+	b := []backend{
+		{dialect.SQLite, filepath.Join(t.TempDir(), "test.db")},
+	}
+	if dsn := os.Getenv("SNIPPETBOX_TEST_MYSQL_DSN"); dsn != "" {
+		b = append(b, backend{dialect.MySQL, dsn})
+	}
+	if dsn := os.Getenv("SNIPPETBOX_TEST_POSTGRES_DSN"); dsn != "" {
+		b = append(b, backend{dialect.Postgres, dsn})
+	}
+	return b
+}
+
+// open the backend database and migrate it up. the schema is migrated back down
+// again when the test finishes, so that the next run starts from an empty database
+func newTestDB(t *testing.T, b backend) (*models.SnippetModel, *models.UserModel) {
+	db, err := dialect.Open(b.dialect, b.dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := migrations.For(b.dialect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := migrate.New(db, b.dialect, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		defer db.Close()
+		if _, err := m.Down(len(m.Migrations)); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	return &models.SnippetModel{DB: db, Dialect: b.dialect}, &models.UserModel{DB: db, Dialect: b.dialect}
+}
+
+// the conformance suite. every storage backend must pass all of these tests
+func TestConformance(t *testing.T) {
+	for _, b := range backends(t) {
+		t.Run(b.dialect.Name(), func(t *testing.T) {
+			snippets, users := newTestDB(t, b)
+
+			// fixture users shared by the tests below. these run in order, and later
+			// tests rely on the rows created by earlier ones
+			_, err := users.Insert("Alice", "alice@example.com", "pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = users.Insert("Bob", "bob@example.com", "pa$$word")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			t.Run("Users", func(t *testing.T) {
+				testUsers(t, users)
+			})
+			t.Run("Snippets", func(t *testing.T) {
+				testSnippets(t, snippets)
+			})
+			t.Run("Pagination", func(t *testing.T) {
+				testPagination(t, snippets)
+			})
+			t.Run("Search", func(t *testing.T) {
+				testSearch(t, snippets)
+			})
+			t.Run("DeleteExpired", func(t *testing.T) {
+				testDeleteExpired(t, snippets)
+			})
+			t.Run("Tokens", func(t *testing.T) {
+				testTokens(t, &models.TokenModel{DB: snippets.DB, Dialect: b.dialect})
+			})
+			t.Run("PasswordResets", func(t *testing.T) {
+				testPasswordResets(t, &models.PasswordResetModel{DB: snippets.DB, Dialect: b.dialect}, users)
+			})
+			t.Run("Activation", func(t *testing.T) {
+				testActivation(t, users)
+			})
+			t.Run("Roles", func(t *testing.T) {
+				testRoles(t, users)
+			})
+			t.Run("Admin", func(t *testing.T) {
+				testAdmin(t, &models.AdminModel{DB: snippets.DB, Dialect: b.dialect}, snippets, users)
+			})
+			t.Run("TwoFactor", func(t *testing.T) {
+				testTwoFactor(t, &models.TwoFactorModel{DB: snippets.DB, Dialect: b.dialect})
+			})
+			t.Run("Throttle", func(t *testing.T) {
+				testThrottle(t, &models.ThrottleModel{DB: snippets.DB, Dialect: b.dialect})
+			})
+			t.Run("UserSessions", func(t *testing.T) {
+				testUserSessions(t, &models.UserSessionModel{DB: snippets.DB, Dialect: b.dialect})
+			})
+			t.Run("Sessions", func(t *testing.T) {
+				sessions := &models.SessionModel{DB: snippets.DB, Dialect: b.dialect}
+				n, err := sessions.CountExpired()
+				assert.NilError(t, err)
+				assert.Equal(t, n, 0)
+			})
+		})
+	}
+}
+
+func testUsers(t *testing.T, users *models.UserModel) {
+	_, err := users.Insert("Alice Again", "alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrDuplicateEmail), true)
+
+	id, err := users.Authenticate("alice@example.com", "pa$$word")
+	assert.NilError(t, err)
+	assert.Equal(t, id, 1)
+
+	_, err = users.Authenticate("alice@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+
+	_, err = users.Authenticate("nobody@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+
+	exists, err := users.Exists(1)
+	assert.NilError(t, err)
+	assert.Equal(t, exists, true)
+
+	exists, err = users.Exists(99)
+	assert.NilError(t, err)
+	assert.Equal(t, exists, false)
+
+	u, err := users.Get(1)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Name, "Alice")
+	assert.Equal(t, u.Email, "alice@example.com")
+	assert.Equal(t, len(u.HashedPassword), 0)
+
+	_, err = users.Get(99)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	err = users.PasswordUpdate(1, "wrong", "new pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+
+	// change the password and back again, so that later tests can still log in
+	err = users.PasswordUpdate(1, "pa$$word", "new pa$$word")
+	assert.NilError(t, err)
+	_, err = users.Authenticate("alice@example.com", "pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	err = users.PasswordUpdate(1, "new pa$$word", "pa$$word")
+	assert.NilError(t, err)
+}
+
+func testSnippets(t *testing.T, snippets *models.SnippetModel) {
+	id, err := snippets.Insert(1, "An old silent pond", "An old silent pond...", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Title, "An old silent pond")
+	assert.Equal(t, s.UserID, 1)
+	assert.Equal(t, s.UserName, "Alice")
+	assert.Equal(t, s.Expires.After(s.Created), true)
+
+	// expired snippets are never returned
+	expired, err := snippets.Insert(1, "Expired", "gone", -1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = snippets.Get(expired)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	err = snippets.Update(id, 1, "A new silent pond", "A new silent pond...", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err = snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Title, "A new silent pond")
+
+	// keeping the expiry leaves it alone, and saving the same contents again isn't an error
+	expires := s.Expires
+	for i := 0; i < 2; i++ {
+		err = snippets.Update(id, 1, "A new silent pond", "A new silent pond...", models.KeepExpiry)
+		assert.NilError(t, err)
+	}
+	s, err = snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Expires.Equal(expires), true)
+
+	// only the author can update a snippet, and only while it hasn't expired or gone
+	err = snippets.Update(id, 2, "Stolen", "Stolen", 1)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = snippets.Update(expired, 1, "Revived", "Revived", 1)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = snippets.Update(9999, 1, "Missing", "Missing", 1)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	s, err = snippets.Get(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, s.Title, "A new silent pond")
+
+	byUser, err := snippets.ByUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(byUser), 1)
+
+	err = snippets.Delete(id)
+	assert.NilError(t, err)
+	_, err = snippets.Get(id)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = snippets.Delete(id)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func testPagination(t *testing.T, snippets *models.SnippetModel) {
+	var ids []int
+	for i := 0; i < 5; i++ {
+		id, err := snippets.Insert(2, "Page", "paged snippet", 7)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, id)
+	}
+
+	// first page holds the two newest snippets
+	page1, p, err := snippets.Latest(models.Cursor{Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(page1), 2)
+	assert.Equal(t, page1[0].ID, ids[4])
+	assert.Equal(t, p.HasNewer, false)
+	assert.Equal(t, p.HasOlder, true)
+
+	// walking forward
+	page2, p, err := snippets.Latest(models.Cursor{After: p.OldestID, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(page2), 2)
+	assert.Equal(t, page2[0].ID, ids[2])
+	assert.Equal(t, p.HasNewer, true)
+
+	// and back again
+	back, p, err := snippets.Latest(models.Cursor{Before: p.NewestID, Size: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(back), 2)
+	assert.Equal(t, back[0].ID, ids[4])
+	assert.Equal(t, p.HasNewer, false)
+}
+
+func testSearch(t *testing.T, snippets *models.SnippetModel) {
+	_, err := snippets.Insert(1, "Frogs", "The frog jumps into the water", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found, more, err := snippets.Search("frog", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(found), 1)
+	assert.Equal(t, found[0].Title, "Frogs")
+	assert.Equal(t, more, false)
+
+	found, _, err = snippets.Search("elephant", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, len(found), 0)
+}
+
+func testDeleteExpired(t *testing.T, snippets *models.SnippetModel) {
+	for i := 0; i < 3; i++ {
+		if _, err := snippets.Insert(1, "Expired", "gone", -1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	live, err := snippets.Insert(1, "Live", "still here", 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the expired snippet left by testSnippets plus the three above, in batches of two
+	n, err := snippets.DeleteExpired(2)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 2)
+
+	n, err = snippets.DeleteExpired(2)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 2)
+
+	n, err = snippets.DeleteExpired(2)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+
+	_, err = snippets.Get(live)
+	assert.NilError(t, err)
+}
+
+func testTokens(t *testing.T, tokens *models.TokenModel) {
+	plaintext, err := tokens.Insert(1, "ci", []string{models.ScopeRead, models.ScopeWrite}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, strings.HasPrefix(plaintext, models.TokenPrefix), true)
+
+	tok, err := tokens.Authenticate(plaintext)
+	assert.NilError(t, err)
+	assert.Equal(t, tok.UserID, 1)
+	assert.Equal(t, tok.Name, "ci")
+	assert.Equal(t, tok.HasScope(models.ScopeWrite), true)
+	assert.Equal(t, tok.Expires.IsZero(), true)
+
+	_, err = tokens.Authenticate(models.TokenPrefix + "nosuchtoken")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	// a token with an expiry works until it runs out. we wind the expiry back to the
+	// creation time to make it run out now
+	expiring, err := tokens.Insert(1, "expiring", []string{models.ScopeRead}, 30)
+	assert.NilError(t, err)
+	tok, err = tokens.Authenticate(expiring)
+	assert.NilError(t, err)
+	assert.Equal(t, tok.HasScope(models.ScopeWrite), false)
+	assert.Equal(t, tok.Expires.After(tok.Created), true)
+
+	_, err = tokens.DB.Exec(tokens.Dialect.Rebind("UPDATE tokens SET expires = created WHERE id = ?"), tok.ID)
+	assert.NilError(t, err)
+	_, err = tokens.Authenticate(expiring)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	list, err := tokens.ForUser(1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 2)
+	assert.Equal(t, list[0].Name, "expiring")
+
+	// users can only revoke their own tokens
+	err = tokens.Delete(2, list[1].ID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	err = tokens.Delete(1, list[1].ID)
+	assert.NilError(t, err)
+	_, err = tokens.Authenticate(plaintext)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	// revoking all of a user's tokens leaves everybody else's alone
+	_, err = tokens.Insert(2, "other", []string{models.ScopeRead}, 0)
+	assert.NilError(t, err)
+	n, err := tokens.DeleteAll(1)
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+	list, err = tokens.ForUser(1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 0)
+	list, err = tokens.ForUser(2)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 1)
+}
+
+func testPasswordResets(t *testing.T, resets *models.PasswordResetModel, users *models.UserModel) {
+	u, err := users.GetByEmail("bob@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, u.Name, "Bob")
+
+	_, err = users.GetByEmail("nobody@example.com")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	first, err := resets.Insert(u.ID, 60)
+	assert.NilError(t, err)
+
+	// a newer token replaces the first one
+	second, err := resets.Insert(u.ID, 60)
+	assert.NilError(t, err)
+	_, err = resets.UserID(first)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	id, err := resets.UserID(second)
+	assert.NilError(t, err)
+	assert.Equal(t, id, u.ID)
+
+	// tokens can only be used once
+	id, err = resets.Consume(second)
+	assert.NilError(t, err)
+	assert.Equal(t, id, u.ID)
+	_, err = resets.Consume(second)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	// expired tokens don't work
+	expired, err := resets.Insert(u.ID, 60)
+	assert.NilError(t, err)
+	_, err = resets.DB.Exec(resets.Dialect.Rebind("UPDATE password_resets SET expires = "+resets.Dialect.Now()+" WHERE user_id = ?"), u.ID)
+	assert.NilError(t, err)
+	_, err = resets.Consume(expired)
+	assert.Equal(t, errors.Is(err, models.ErrInvalidToken), true)
+
+	err = users.PasswordReset(u.ID, "new pa$$word")
+	assert.NilError(t, err)
+	_, err = users.Authenticate("bob@example.com", "new pa$$word")
+	assert.NilError(t, err)
+
+	err = users.PasswordReset(99, "new pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func testActivation(t *testing.T, users *models.UserModel) {
+	id, err := users.Insert("Carol", "carol@example.com", "pa$$word")
+	assert.NilError(t, err)
+
+	u, err := users.Get(id)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Activated, false)
+
+	// the first email can always be sent, and another one only after the interval
+	sent, err := users.RecordActivationSent(id, 300)
+	assert.NilError(t, err)
+	assert.Equal(t, sent, true)
+	sent, err = users.RecordActivationSent(id, 300)
+	assert.NilError(t, err)
+	assert.Equal(t, sent, false)
+	sent, err = users.RecordActivationSent(id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, sent, true)
+
+	// activating twice is fine
+	assert.NilError(t, users.Activate(id))
+	assert.NilError(t, users.Activate(id))
+	u, err = users.GetByEmail("carol@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, u.Activated, true)
+
+	// there's no need for emails once the user is activated
+	sent, err = users.RecordActivationSent(id, 0)
+	assert.NilError(t, err)
+	assert.Equal(t, sent, false)
+
+	err = users.Activate(99)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+}
+
+func testTwoFactor(t *testing.T, twoFactor *models.TwoFactorModel) {
+	secret, err := twoFactor.Secret(1)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "")
+
+	_, err = twoFactor.Secret(99)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	_, err = twoFactor.Enable(99, "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	codes, err := twoFactor.Enable(1, "JBSWY3DPEHPK3PXP")
+	assert.NilError(t, err)
+	assert.Equal(t, len(codes), models.RecoveryCodeCount)
+
+	secret, err = twoFactor.Secret(1)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "JBSWY3DPEHPK3PXP")
+
+	// each step can only be used once, and never an earlier one
+	ok, err := twoFactor.UseStep(1, 1000)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	ok, err = twoFactor.UseStep(1, 1000)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	ok, err = twoFactor.UseStep(1, 999)
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	// recovery codes work once, however they are typed, and only for their owner
+	ok, err = twoFactor.UseRecoveryCode(2, codes[0])
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+	ok, err = twoFactor.UseRecoveryCode(1, strings.ToUpper(strings.ReplaceAll(codes[0], "-", " ")))
+	assert.NilError(t, err)
+	assert.Equal(t, ok, true)
+	ok, err = twoFactor.UseRecoveryCode(1, codes[0])
+	assert.NilError(t, err)
+	assert.Equal(t, ok, false)
+
+	left, err := twoFactor.RecoveryCodesLeft(1)
+	assert.NilError(t, err)
+	assert.Equal(t, left, models.RecoveryCodeCount-1)
+
+	err = twoFactor.Disable(1)
+	assert.NilError(t, err)
+	secret, err = twoFactor.Secret(1)
+	assert.NilError(t, err)
+	assert.Equal(t, secret, "")
+	left, err = twoFactor.RecoveryCodesLeft(1)
+	assert.NilError(t, err)
+	assert.Equal(t, left, 0)
+}
+
+func testThrottle(t *testing.T, throttle *models.ThrottleModel) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	e, err := throttle.Get("ip:192.0.2.1", now)
+	assert.NilError(t, err)
+	assert.Equal(t, e, models.ThrottleEntry{})
+
+	for i := 1; i <= 3; i++ {
+		e, err = throttle.Add("ip:192.0.2.1", now.Add(time.Duration(i)*time.Second), now.Add(time.Hour))
+		assert.NilError(t, err)
+		assert.Equal(t, e.Failures, i)
+	}
+	assert.Equal(t, e.Last.Equal(now.Add(3*time.Second)), true)
+
+	// taking a failure back leaves the time of the latest one alone
+	assert.NilError(t, throttle.Sub("ip:192.0.2.1"))
+	e, err = throttle.Get("ip:192.0.2.1", now)
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 2)
+	assert.Equal(t, e.Last.Equal(now.Add(3*time.Second)), true)
+	assert.NilError(t, throttle.Sub("ip:192.0.2.9"))
+
+	// other keys are counted separately
+	e, err = throttle.Add("ip:192.0.2.2", now, now.Add(time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 1)
+
+	// once an entry expires it is no longer returned, and counting starts again
+	later := now.Add(2 * time.Minute)
+	e, err = throttle.Get("ip:192.0.2.2", later)
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 0)
+	e, err = throttle.Add("ip:192.0.2.2", later, later.Add(time.Minute))
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 1)
+
+	n, err := throttle.DeleteExpired(now.Add(2 * time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 2)
+
+	_, err = throttle.Add("email:alice@example.com", now, now.Add(time.Hour))
+	assert.NilError(t, err)
+	assert.NilError(t, throttle.Delete("email:alice@example.com"))
+	e, err = throttle.Get("email:alice@example.com", now)
+	assert.NilError(t, err)
+	assert.Equal(t, e.Failures, 0)
+}
+
+func testUserSessions(t *testing.T, sessions *models.UserSessionModel) {
+	err := sessions.Insert(1, "laptop-token", "Mozilla/5.0 (X11; Linux x86_64) Firefox/115.0", "192.0.2.1", time.Hour)
+	assert.NilError(t, err)
+	err = sessions.Insert(1, "phone-token", strings.Repeat("x", 300), "192.0.2.2", time.Hour)
+	assert.NilError(t, err)
+	err = sessions.Insert(2, "bob-token", "curl/8.0", "192.0.2.3", time.Hour)
+	assert.NilError(t, err)
+
+	s, err := sessions.Get("laptop-token")
+	assert.NilError(t, err)
+	assert.Equal(t, s.UserID, 1)
+	assert.Equal(t, s.IP, "192.0.2.1")
+	assert.Equal(t, s.Expires.After(s.Created), true)
+
+	_, err = sessions.Get("nosuchtoken")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	err = sessions.Touch(s.ID, "198.51.100.1")
+	assert.NilError(t, err)
+	s, err = sessions.Get("laptop-token")
+	assert.NilError(t, err)
+	assert.Equal(t, s.IP, "198.51.100.1")
+
+	list, err := sessions.ForUser(1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(list), 2)
+	for _, s := range list {
+		assert.Equal(t, len(s.UserAgent) <= models.MaxUserAgentLength, true)
+	}
+
+	// users can only revoke their own sessions
+	bob, err := sessions.Get("bob-token")
+	assert.NilError(t, err)
+	err = sessions.Delete(1, bob.ID)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	n, err := sessions.DeleteAllExcept(1, "laptop-token")
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+	_, err = sessions.Get("phone-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	_, err = sessions.Get("bob-token")
+	assert.NilError(t, err)
+
+	err = sessions.DeleteByToken("laptop-token")
+	assert.NilError(t, err)
+	_, err = sessions.Get("laptop-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// expired sessions aren't logged in any more, and are cleaned up by DeleteExpired
+	_, err = sessions.DB.Exec(sessions.Dialect.Rebind("UPDATE user_sessions SET expires = created WHERE id = ?"), bob.ID)
+	assert.NilError(t, err)
+	_, err = sessions.Get("bob-token")
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	n, err = sessions.DeleteExpired()
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+}
+
+func testRoles(t *testing.T, users *models.UserModel) {
+	u, err := users.GetByEmail("bob@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, u.Role, models.RoleUser)
+	assert.Equal(t, u.HasRole(models.RoleModerator), false)
+
+	err = users.SetRole(u.ID, models.RoleModerator)
+	assert.NilError(t, err)
+	// setting the same role again is fine
+	err = users.SetRole(u.ID, models.RoleModerator)
+	assert.NilError(t, err)
+
+	u, err = users.Get(u.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Role, models.RoleModerator)
+	assert.Equal(t, u.HasRole(models.RoleUser), true)
+	assert.Equal(t, u.HasRole(models.RoleModerator), true)
+	assert.Equal(t, u.HasRole(models.RoleAdmin), false)
+
+	err = users.SetRole(u.ID, "superuser")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidRole), true)
+	err = users.SetRole(999, models.RoleAdmin)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	err = users.SetRole(u.ID, models.RoleUser)
+	assert.NilError(t, err)
+}
+
+func testAdmin(t *testing.T, admin *models.AdminModel, snippets *models.SnippetModel, users *models.UserModel) {
+	bob, err := users.GetByEmail("bob@example.com")
+	assert.NilError(t, err)
+
+	before, err := admin.Stats()
+	assert.NilError(t, err)
+
+	// searches ignore case and treat LIKE wildcards as plain characters
+	found, more, err := admin.Users("BOB", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, more, false)
+	assert.Equal(t, len(found), 1)
+	assert.Equal(t, found[0].ID, bob.ID)
+	found, _, err = admin.Users("%", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(found), 0)
+	found, _, err = admin.Users("", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(found), before.Users)
+
+	// disabled users can't log in. the password was changed by testPasswordResets
+	err = admin.SetUserDisabled(bob.ID, true)
+	assert.NilError(t, err)
+	err = admin.SetUserDisabled(bob.ID, true)
+	assert.NilError(t, err)
+	_, err = users.Authenticate("bob@example.com", "new pa$$word")
+	assert.Equal(t, errors.Is(err, models.ErrUserDisabled), true)
+	_, err = users.Authenticate("bob@example.com", "wrong")
+	assert.Equal(t, errors.Is(err, models.ErrInvalidCredentials), true)
+	u, err := users.Get(bob.ID)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Disabled, true)
+
+	stats, err := admin.Stats()
+	assert.NilError(t, err)
+	assert.Equal(t, stats.DisabledUsers, before.DisabledUsers+1)
+
+	err = admin.SetUserDisabled(bob.ID, false)
+	assert.NilError(t, err)
+	_, err = users.Authenticate("bob@example.com", "new pa$$word")
+	assert.NilError(t, err)
+	err = admin.SetUserDisabled(999, true)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	// expiring a snippet hides it from the site, but admins can still find it
+	id, err := snippets.Insert(bob.ID, "Admin 100% test", "content", 7)
+	assert.NilError(t, err)
+	err = admin.ExpireSnippet(id)
+	assert.NilError(t, err)
+	_, err = snippets.Get(id)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+	err = admin.ExpireSnippet(id)
+	assert.NilError(t, err)
+	err = admin.ExpireSnippet(999999)
+	assert.Equal(t, errors.Is(err, models.ErrNoRecord), true)
+
+	listed, _, err := admin.Snippets("100%", 1)
+	assert.NilError(t, err)
+	assert.Equal(t, len(listed), 1)
+	assert.Equal(t, listed[0].ID, id)
+	assert.Equal(t, listed[0].UserName, "Bob")
+
+	stats, err = admin.Stats()
+	assert.NilError(t, err)
+	assert.Equal(t, stats.Snippets, before.Snippets+1)
+	assert.Equal(t, stats.LiveSnippets, before.LiveSnippets)
+
+	// every day in the range is there, and everything created during the test counts as today
+	now := time.Now().UTC()
+	counts, err := admin.DailyCounts(now.AddDate(0, 0, -6), now)
+	assert.NilError(t, err)
+	assert.Equal(t, len(counts), 7)
+	assert.Equal(t, counts[0].Day.Format("2006-01-02"), now.AddDate(0, 0, -6).Format("2006-01-02"))
+	today := counts[len(counts)-1]
+	assert.Equal(t, today.Day.Format("2006-01-02"), now.Format("2006-01-02"))
+	assert.Equal(t, today.Signups, stats.Users)
+	assert.Equal(t, today.Snippets, stats.Snippets)
+
+	err = snippets.Delete(id)
+	assert.NilError(t, err)
+}
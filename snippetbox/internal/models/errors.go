@@ -14,4 +14,13 @@ var (
 
 	//error if a user tries to signup with an email address that is already in use
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+
+	// error if an API token doesn't exist or has expired
+	ErrInvalidToken = errors.New("models: invalid token")
+
+	// error if a user is given a role which doesn't exist
+	ErrInvalidRole = errors.New("models: invalid role")
+
+	// error if a disabled user gives the right password
+	ErrUserDisabled = errors.New("models: user disabled")
 )
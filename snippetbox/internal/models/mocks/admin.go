@@ -0,0 +1,160 @@
+package mocks
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// in-memory stand-in for models.AdminModel, used by the handler tests. it works on the data
+// in the mock UserModel and SnippetModel it is given, so that changes show up in both
+type AdminModel struct {
+	users    *UserModel
+	snippets *SnippetModel
+}
+
+// create a mock AdminModel over the given mock models
+func NewAdminModel(users *UserModel, snippets *SnippetModel) *AdminModel {
+	return &AdminModel{users: users, snippets: snippets}
+}
+
+func (m *AdminModel) Stats() (*models.AdminStats, error) {
+	s := &models.AdminStats{}
+
+	m.users.mu.Lock()
+	for _, u := range m.users.users {
+		s.Users++
+		if u.Disabled {
+			s.DisabledUsers++
+		}
+	}
+	m.users.mu.Unlock()
+
+	m.snippets.mu.Lock()
+	for _, sn := range m.snippets.snippets {
+		s.Snippets++
+		if sn.Expires.After(time.Now()) {
+			s.LiveSnippets++
+		}
+	}
+	m.snippets.mu.Unlock()
+
+	return s, nil
+}
+
+func (m *AdminModel) DailyCounts(from, to time.Time) ([]models.DailyCount, error) {
+	day := func(t time.Time) time.Time {
+		return t.UTC().Truncate(24 * time.Hour)
+	}
+
+	counts := []models.DailyCount{}
+	index := map[time.Time]int{}
+	for t := day(from); !t.After(day(to)); t = t.AddDate(0, 0, 1) {
+		index[t] = len(counts)
+		counts = append(counts, models.DailyCount{Day: t})
+	}
+
+	m.users.mu.Lock()
+	for _, u := range m.users.users {
+		if i, ok := index[day(u.Created)]; ok {
+			counts[i].Signups++
+		}
+	}
+	m.users.mu.Unlock()
+
+	m.snippets.mu.Lock()
+	for _, s := range m.snippets.snippets {
+		if i, ok := index[day(s.Created)]; ok {
+			counts[i].Snippets++
+		}
+	}
+	m.snippets.mu.Unlock()
+
+	return counts, nil
+}
+
+func (m *AdminModel) Users(query string, page int) ([]*models.User, bool, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	m.users.mu.Lock()
+	users := []*models.User{}
+	for _, u := range m.users.users {
+		u := u
+		if strings.Contains(strings.ToLower(u.Name), query) || strings.Contains(strings.ToLower(u.Email), query) {
+			users = append(users, &u)
+		}
+	}
+	m.users.mu.Unlock()
+
+	sort.Slice(users, func(i, j int) bool {
+		return users[i].ID > users[j].ID
+	})
+	users, more := paginate(users, page)
+	return users, more, nil
+}
+
+func (m *AdminModel) Snippets(query string, page int) ([]*models.Snippet, bool, error) {
+	query = strings.ToLower(strings.TrimSpace(query))
+
+	m.snippets.mu.Lock()
+	snippets := []*models.Snippet{}
+	for _, s := range m.snippets.snippets {
+		s := s
+		if strings.Contains(strings.ToLower(s.Title), query) || strings.Contains(strings.ToLower(s.UserName), query) {
+			snippets = append(snippets, &s)
+		}
+	}
+	m.snippets.mu.Unlock()
+
+	sort.Slice(snippets, func(i, j int) bool {
+		return snippets[i].ID > snippets[j].ID
+	})
+	snippets, more := paginate(snippets, page)
+	return snippets, more, nil
+}
+
+func (m *AdminModel) SetUserDisabled(id int, disabled bool) error {
+	m.users.mu.Lock()
+	defer m.users.mu.Unlock()
+
+	u, ok := m.users.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	u.Disabled = disabled
+	m.users.users[id] = u
+	return nil
+}
+
+func (m *AdminModel) ExpireSnippet(id int) error {
+	m.snippets.mu.Lock()
+	defer m.snippets.mu.Unlock()
+
+	s, ok := m.snippets.snippets[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	if now := time.Now().UTC(); s.Expires.After(now) {
+		s.Expires = now
+		m.snippets.snippets[id] = s
+	}
+	return nil
+}
+
+// return the given page of items, numbered from 1, and whether there are more after it
+func paginate[T any](items []T, page int) ([]T, bool) {
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * models.DefaultPageSize
+	if start >= len(items) {
+		return []T{}, false
+	}
+	end := start + models.DefaultPageSize
+	if end >= len(items) {
+		return items[start:], false
+	}
+	return items[start:end], true
+}
@@ -0,0 +1,70 @@
+package mocks
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// in-memory stand-in for models.PasswordResetModel, used by the handler tests. the
+// tokens are predictable, like "reset1", "reset2" and so on
+type PasswordResetModel struct {
+	mu      sync.Mutex
+	resets  map[string]passwordReset
+	counter int
+}
+
+type passwordReset struct {
+	userID  int
+	expires time.Time
+}
+
+// create a new, empty mock PasswordResetModel
+func NewPasswordResetModel() *PasswordResetModel {
+	return &PasswordResetModel{resets: map[string]passwordReset{}}
+}
+
+func (m *PasswordResetModel) Insert(userID int, expiresMinutes int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, r := range m.resets {
+		if r.userID == userID {
+			delete(m.resets, token)
+		}
+	}
+
+	m.counter++
+	token := fmt.Sprintf("reset%d", m.counter)
+	m.resets[token] = passwordReset{userID: userID, expires: time.Now().Add(time.Duration(expiresMinutes) * time.Minute)}
+	return token, nil
+}
+
+func (m *PasswordResetModel) UserID(plaintext string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.resets[plaintext]
+	if !ok || !r.expires.After(time.Now()) {
+		return 0, models.ErrInvalidToken
+	}
+	return r.userID, nil
+}
+
+func (m *PasswordResetModel) Consume(plaintext string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	r, ok := m.resets[plaintext]
+	if !ok || !r.expires.After(time.Now()) {
+		return 0, models.ErrInvalidToken
+	}
+	for token, other := range m.resets {
+		if other.userID == r.userID {
+			delete(m.resets, token)
+		}
+	}
+	return r.userID, nil
+}
@@ -0,0 +1,185 @@
+package mocks
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// fixture snippet which is always present in a new mock SnippetModel. it belongs to MockUser
+var MockSnippet = models.Snippet{
+	ID:       1,
+	UserID:   1,
+	UserName: "Alice",
+	Title:    "An old silent pond",
+	Content:  "An old silent pond...",
+	Created:  time.Date(2022, 3, 17, 10, 15, 0, 0, time.UTC),
+	Expires:  time.Date(2099, 3, 17, 10, 15, 0, 0, time.UTC),
+}
+
+// in-memory stand-in for models.SnippetModel, used by the handler tests
+type SnippetModel struct {
+	mu       sync.Mutex
+	snippets map[int]models.Snippet
+	nextID   int
+}
+
+// create a new mock SnippetModel holding the fixture data
+func NewSnippetModel() *SnippetModel {
+	return &SnippetModel{
+		snippets: map[int]models.Snippet{MockSnippet.ID: MockSnippet},
+		nextID:   MockSnippet.ID + 1,
+	}
+}
+
+func (m *SnippetModel) Insert(userID int, title, content string, expires int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextID
+	m.nextID++
+
+	now := time.Now().UTC()
+	m.snippets[id] = models.Snippet{
+		ID:       id,
+		UserID:   userID,
+		UserName: userName(userID),
+		Title:    title,
+		Content:  content,
+		Created:  now,
+		Expires:  now.AddDate(0, 0, expires),
+	}
+	return id, nil
+}
+
+func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snippets[id]
+	if !ok || !s.Expires.After(time.Now()) {
+		return nil, models.ErrNoRecord
+	}
+	return &s, nil
+}
+
+func (m *SnippetModel) Latest(cursor models.Cursor) ([]*models.Snippet, models.Page, error) {
+	all := m.filter(func(s *models.Snippet) bool {
+		return (cursor.After == 0 || s.ID < cursor.After) && (cursor.Before == 0 || s.ID > cursor.Before)
+	})
+
+	size := cursor.Size
+	if size <= 0 || size > models.MaxPageSize {
+		size = models.DefaultPageSize
+	}
+
+	var page models.Page
+	if cursor.Before > 0 {
+		// keep the page closest to the cursor
+		page.HasNewer = len(all) > size
+		page.HasOlder = true
+		if len(all) > size {
+			all = all[len(all)-size:]
+		}
+	} else {
+		page.HasNewer = cursor.After > 0
+		page.HasOlder = len(all) > size
+		if len(all) > size {
+			all = all[:size]
+		}
+	}
+	if len(all) > 0 {
+		page.NewestID = all[0].ID
+		page.OldestID = all[len(all)-1].ID
+	}
+	return all, page, nil
+}
+
+func (m *SnippetModel) ByUser(userID int) ([]*models.Snippet, error) {
+	return m.filter(func(s *models.Snippet) bool {
+		return s.UserID == userID
+	}), nil
+}
+
+func (m *SnippetModel) Search(query string, page int) ([]*models.Snippet, bool, error) {
+	query = strings.ToLower(query)
+	all := m.filter(func(s *models.Snippet) bool {
+		return strings.Contains(strings.ToLower(s.Title+" "+s.Content), query)
+	})
+
+	if page < 1 {
+		page = 1
+	}
+	start := (page - 1) * models.DefaultPageSize
+	if start >= len(all) {
+		return []*models.Snippet{}, false, nil
+	}
+	end := start + models.DefaultPageSize
+	if end >= len(all) {
+		return all[start:], false, nil
+	}
+	return all[start:end], true, nil
+}
+
+func (m *SnippetModel) Update(id, userID int, title, content string, expires int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.snippets[id]
+	if !ok || s.UserID != userID || !s.Expires.After(time.Now()) {
+		return models.ErrNoRecord
+	}
+	s.Title = title
+	s.Content = content
+	if expires != models.KeepExpiry {
+		s.Expires = time.Now().UTC().AddDate(0, 0, expires)
+	}
+	m.snippets[id] = s
+	return nil
+}
+
+func (m *SnippetModel) Delete(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.snippets[id]; !ok {
+		return models.ErrNoRecord
+	}
+	delete(m.snippets, id)
+	return nil
+}
+
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for id, s := range m.snippets {
+		if n < limit && !s.Expires.After(time.Now()) {
+			delete(m.snippets, id)
+			n++
+		}
+	}
+	return n, nil
+}
+
+// return copies of the unexpired snippets for which keep returns true, newest first
+func (m *SnippetModel) filter(keep func(s *models.Snippet) bool) []*models.Snippet {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	snippets := []*models.Snippet{}
+	for _, s := range m.snippets {
+		s := s
+		if s.Expires.After(time.Now()) && keep(&s) {
+			snippets = append(snippets, &s)
+		}
+	}
+	sort.Slice(snippets, func(i, j int) bool {
+		return snippets[i].ID > snippets[j].ID
+	})
+	return snippets
+}
@@ -0,0 +1,112 @@
+package mocks
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// plain-text fixture tokens which are always present in a new mock TokenModel. the first
+// two belong to MockUser, and MockOtherWriteToken belongs to the DupeEmail user
+const (
+	MockReadToken       = "sbx_mockreadtoken"
+	MockWriteToken      = "sbx_mockwritetoken"
+	MockOtherWriteToken = "sbx_mockotherwritetoken"
+)
+
+// in-memory stand-in for models.TokenModel, used by the handler tests. the tokens are
+// kept in plain text, keyed by the token itself
+type TokenModel struct {
+	mu     sync.Mutex
+	tokens map[string]models.Token
+	nextID int
+}
+
+// create a new mock TokenModel holding the fixture data
+func NewTokenModel() *TokenModel {
+	created := time.Date(2022, 3, 17, 10, 30, 0, 0, time.UTC)
+	return &TokenModel{
+		tokens: map[string]models.Token{
+			MockReadToken:       {ID: 1, UserID: MockUser.ID, Name: "read", Scopes: []string{models.ScopeRead}, Created: created},
+			MockWriteToken:      {ID: 2, UserID: MockUser.ID, Name: "write", Scopes: []string{models.ScopeRead, models.ScopeWrite}, Created: created},
+			MockOtherWriteToken: {ID: 3, UserID: 2, Name: "other", Scopes: []string{models.ScopeRead, models.ScopeWrite}, Created: created},
+		},
+		nextID: 4,
+	}
+}
+
+func (m *TokenModel) Insert(userID int, name string, scopes []string, expiresDays int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	id := m.nextID
+	m.nextID++
+
+	now := time.Now().UTC()
+	t := models.Token{ID: id, UserID: userID, Name: name, Scopes: scopes, Created: now}
+	if expiresDays > 0 {
+		t.Expires = now.AddDate(0, 0, expiresDays)
+	}
+
+	plaintext := fmt.Sprintf("%smocktoken%d", models.TokenPrefix, id)
+	m.tokens[plaintext] = t
+	return plaintext, nil
+}
+
+func (m *TokenModel) Authenticate(plaintext string) (*models.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	t, ok := m.tokens[plaintext]
+	if !ok || (!t.Expires.IsZero() && !t.Expires.After(time.Now())) {
+		return nil, models.ErrInvalidToken
+	}
+	return &t, nil
+}
+
+func (m *TokenModel) ForUser(userID int) ([]*models.Token, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	tokens := []*models.Token{}
+	for _, t := range m.tokens {
+		if t.UserID == userID {
+			t := t
+			tokens = append(tokens, &t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		return tokens[i].ID > tokens[j].ID
+	})
+	return tokens, nil
+}
+
+func (m *TokenModel) Delete(userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for plaintext, t := range m.tokens {
+		if t.ID == id && t.UserID == userID {
+			delete(m.tokens, plaintext)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *TokenModel) DeleteAll(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for plaintext, t := range m.tokens {
+		if t.UserID == userID {
+			delete(m.tokens, plaintext)
+			n++
+		}
+	}
+	return n, nil
+}
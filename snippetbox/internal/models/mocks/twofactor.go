@@ -0,0 +1,88 @@
+package mocks
+
+import (
+	"fmt"
+	"sync"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// in-memory stand-in for models.TwoFactorModel, used by the handler tests. nobody has
+// two-factor authentication turned on to start with, and the recovery codes are
+// predictable, like "recovery1", "recovery2" and so on
+type TwoFactorModel struct {
+	mu            sync.Mutex
+	secrets       map[int]string
+	lastSteps     map[int]int64
+	recoveryCodes map[int]map[string]bool
+}
+
+// create a new, empty mock TwoFactorModel
+func NewTwoFactorModel() *TwoFactorModel {
+	return &TwoFactorModel{
+		secrets:       map[int]string{},
+		lastSteps:     map[int]int64{},
+		recoveryCodes: map[int]map[string]bool{},
+	}
+}
+
+func (m *TwoFactorModel) Enable(userID int, secret string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	codes := make([]string, models.RecoveryCodeCount)
+	m.recoveryCodes[userID] = map[string]bool{}
+	for i := range codes {
+		codes[i] = fmt.Sprintf("recovery%d", i+1)
+		m.recoveryCodes[userID][codes[i]] = true
+	}
+	m.secrets[userID] = secret
+	m.lastSteps[userID] = 0
+	return codes, nil
+}
+
+func (m *TwoFactorModel) Disable(userID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.secrets, userID)
+	delete(m.lastSteps, userID)
+	delete(m.recoveryCodes, userID)
+	return nil
+}
+
+func (m *TwoFactorModel) Secret(userID int) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.secrets[userID], nil
+}
+
+func (m *TwoFactorModel) UseStep(userID int, step int64) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if step <= m.lastSteps[userID] {
+		return false, nil
+	}
+	m.lastSteps[userID] = step
+	return true, nil
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !m.recoveryCodes[userID][code] {
+		return false, nil
+	}
+	delete(m.recoveryCodes[userID], code)
+	return true, nil
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.recoveryCodes[userID]), nil
+}
@@ -0,0 +1,202 @@
+package mocks
+
+import (
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// fixture user which is always present in a new mock UserModel
+var MockUser = models.User{
+	ID:        1,
+	Name:      "Alice",
+	Email:     "alice@example.com",
+	Created:   time.Date(2022, 3, 17, 10, 0, 0, 0, time.UTC),
+	Activated: true,
+	Role:      models.RoleUser,
+}
+
+// fixture user who hasn't verified their email address yet. they have the same password as MockUser
+var MockInactiveUser = models.User{
+	ID:      3,
+	Name:    "Carol",
+	Email:   "carol@example.com",
+	Created: time.Date(2022, 3, 18, 10, 0, 0, 0, time.UTC),
+	Role:    models.RoleUser,
+}
+
+// the plain-text password for MockUser
+const MockPassword = "pa$$word"
+
+// address which a new mock UserModel treats as already taken by another account
+const DupeEmail = "dupe@example.com"
+
+// in-memory stand-in for models.UserModel, used by the handler tests. passwords are kept
+// in plain text because there is no need to pay for bcrypt in tests
+type UserModel struct {
+	mu             sync.Mutex
+	users          map[int]models.User
+	passwords      map[int]string
+	activationSent map[int]time.Time
+	nextID         int
+}
+
+// create a new mock UserModel holding the fixture data
+func NewUserModel() *UserModel {
+	return &UserModel{
+		users: map[int]models.User{
+			MockUser.ID:         MockUser,
+			2:                   {ID: 2, Name: "Dupe", Email: DupeEmail, Created: MockUser.Created, Activated: true, Role: models.RoleUser},
+			MockInactiveUser.ID: MockInactiveUser,
+		},
+		passwords: map[int]string{
+			MockUser.ID:         MockPassword,
+			2:                   MockPassword,
+			MockInactiveUser.ID: MockPassword,
+		},
+		activationSent: map[int]time.Time{},
+		nextID:         4,
+	}
+}
+
+func (m *UserModel) Insert(name, email, password string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return 0, models.ErrDuplicateEmail
+		}
+	}
+
+	id := m.nextID
+	m.nextID++
+	m.users[id] = models.User{ID: id, Name: name, Email: email, Created: time.Now().UTC(), Role: models.RoleUser}
+	m.passwords[id] = password
+	return id, nil
+}
+
+func (m *UserModel) Authenticate(email, password string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, u := range m.users {
+		if u.Email == email && m.passwords[id] == password {
+			if u.Disabled {
+				return 0, models.ErrUserDisabled
+			}
+			return id, nil
+		}
+	}
+	return 0, models.ErrInvalidCredentials
+}
+
+func (m *UserModel) Exists(id int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, ok := m.users[id]
+	return ok, nil
+}
+
+func (m *UserModel) Get(id int) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return nil, models.ErrNoRecord
+	}
+	return &u, nil
+}
+
+func (m *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return models.ErrNoRecord
+	}
+	if m.passwords[id] != currentPassword {
+		return models.ErrInvalidCredentials
+	}
+	m.passwords[id] = newPassword
+	return nil
+}
+
+func (m *UserModel) PasswordReset(id int, newPassword string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.users[id]; !ok {
+		return models.ErrNoRecord
+	}
+	m.passwords[id] = newPassword
+	return nil
+}
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, u := range m.users {
+		if u.Email == email {
+			return &u, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *UserModel) Activate(id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	u.Activated = true
+	m.users[id] = u
+	return nil
+}
+
+func (m *UserModel) RecordActivationSent(id int, intervalSeconds int) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[id]; !ok || u.Activated {
+		return false, nil
+	}
+	interval := time.Duration(intervalSeconds) * time.Second
+	if last, ok := m.activationSent[id]; ok && time.Since(last) < interval {
+		return false, nil
+	}
+	m.activationSent[id] = time.Now()
+	return true, nil
+}
+
+func (m *UserModel) SetRole(id int, role string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if !models.ValidRole(role) {
+		return models.ErrInvalidRole
+	}
+	u, ok := m.users[id]
+	if !ok {
+		return models.ErrNoRecord
+	}
+	u.Role = role
+	m.users[id] = u
+	return nil
+}
+
+// fixture names for the snippet mocks, so that new snippets get an author name like
+// they would from the join in the real model
+func userName(id int) string {
+	if id == MockUser.ID {
+		return MockUser.Name
+	}
+	return ""
+}
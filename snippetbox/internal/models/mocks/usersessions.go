@@ -0,0 +1,131 @@
+package mocks
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// in-memory stand-in for models.UserSessionModel, used by the handler tests. the sessions
+// are keyed by the plain session token, and there are none to start with
+type UserSessionModel struct {
+	mu       sync.Mutex
+	sessions map[string]models.UserSession
+	nextID   int
+}
+
+// create a new, empty mock UserSessionModel
+func NewUserSessionModel() *UserSessionModel {
+	return &UserSessionModel{sessions: map[string]models.UserSession{}, nextID: 1}
+}
+
+func (m *UserSessionModel) Insert(userID int, token, userAgent, ip string, lifetime time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now().UTC()
+	m.sessions[token] = models.UserSession{
+		ID:        m.nextID,
+		UserID:    userID,
+		UserAgent: userAgent,
+		IP:        ip,
+		Created:   now,
+		LastSeen:  now,
+		Expires:   now.Add(lifetime),
+	}
+	m.nextID++
+	return nil
+}
+
+func (m *UserSessionModel) Get(token string) (*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s, ok := m.sessions[token]
+	if !ok || !s.Expires.After(time.Now()) {
+		return nil, models.ErrNoRecord
+	}
+	return &s, nil
+}
+
+func (m *UserSessionModel) Touch(id int, ip string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, s := range m.sessions {
+		if s.ID == id {
+			s.LastSeen = time.Now().UTC()
+			s.IP = ip
+			m.sessions[token] = s
+		}
+	}
+	return nil
+}
+
+func (m *UserSessionModel) ForUser(userID int) ([]*models.UserSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	sessions := []*models.UserSession{}
+	for _, s := range m.sessions {
+		if s.UserID == userID && s.Expires.After(time.Now()) {
+			s := s
+			sessions = append(sessions, &s)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ID > sessions[j].ID
+	})
+	return sessions, nil
+}
+
+func (m *UserSessionModel) Delete(userID, id int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for token, s := range m.sessions {
+		if s.ID == id && s.UserID == userID {
+			delete(m.sessions, token)
+			return nil
+		}
+	}
+	return models.ErrNoRecord
+}
+
+func (m *UserSessionModel) DeleteByToken(token string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, token)
+	return nil
+}
+
+func (m *UserSessionModel) DeleteAllExcept(userID int, keep string) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for token, s := range m.sessions {
+		if s.UserID == userID && token != keep {
+			delete(m.sessions, token)
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (m *UserSessionModel) DeleteExpired() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var n int
+	for token, s := range m.sessions {
+		if !s.Expires.After(time.Now()) {
+			delete(m.sessions, token)
+			n++
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,37 @@
+package models
+
+const (
+	// page size used when a cursor doesn't ask for one
+	DefaultPageSize = 10
+
+	// upper bound on the page size, so that a single request can't pull back the whole table
+	MaxPageSize = 100
+)
+
+// Cursor identifies a single page in a keyset (cursor) paginated listing which is
+// ordered newest first. rather than skipping over rows with OFFSET, we remember the id
+// at the edge of the page the user is looking at and continue from there.
+type Cursor struct {
+	After  int // return the records that come after (are older than) this id
+	Before int // return the records that come before (are newer than) this id
+	Size   int // number of records per page. zero means DefaultPageSize
+}
+
+// return the page size to use for this cursor, clamped between 1 and MaxPageSize
+func (c Cursor) limit() int {
+	switch {
+	case c.Size <= 0:
+		return DefaultPageSize
+	case c.Size > MaxPageSize:
+		return MaxPageSize
+	}
+	return c.Size
+}
+
+// Page describes where a page of results sits in the full listing.
+type Page struct {
+	NewestID int  // id of the first (newest) record on the page
+	OldestID int  // id of the last (oldest) record on the page
+	HasNewer bool // true if there are newer records before this page
+	HasOlder bool // true if there are older records after this page
+}
@@ -0,0 +1,104 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the methods that handlers need from the store of password reset tokens
+type PasswordResetModelInterface interface {
+	Insert(userID int, expiresMinutes int) (string, error)
+	UserID(plaintext string) (int, error)
+	Consume(plaintext string) (int, error)
+}
+
+// define a PasswordResetModel type which wraps a sql.DB connection pool. reset tokens are
+// stored as a hash, like API tokens, and can only be used once. the Dialect defaults to
+// MySQL when it isn't set.
+type PasswordResetModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// create a reset token for the user which lasts for expiresMinutes, and return the plain-text
+// token to send them. any earlier token for the user stops working, so only the newest link
+// in their inbox can be used
+func (m *PasswordResetModel) Insert(userID int, expiresMinutes int) (string, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	plaintext, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+
+	// tidy up expired tokens for every user while we're here, since nothing else removes them
+	stmt := "DELETE FROM password_resets WHERE user_id = ? OR expires <= " + d.Now()
+	if _, err := m.DB.Exec(d.Rebind(stmt), userID); err != nil {
+		return "", err
+	}
+
+	stmt = `
+		INSERT INTO
+			password_resets (hash, user_id, expires)
+		VALUES(
+			?, ?, ` + d.NowPlus("MINUTE") + `
+		);
+	`
+	_, err = m.DB.Exec(d.Rebind(stmt), hashToken(plaintext), userID, expiresMinutes)
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// return the id of the user a reset token belongs to, without using the token up. if the
+// token doesn't exist or has expired we return ErrInvalidToken
+func (m *PasswordResetModel) UserID(plaintext string) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	var userID int
+	stmt := "SELECT user_id FROM password_resets WHERE hash = ? AND expires > " + d.Now()
+
+	err := m.DB.QueryRow(d.Rebind(stmt), hashToken(plaintext)).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidToken
+		}
+		return 0, err
+	}
+	return userID, nil
+}
+
+// use up a reset token, returning the id of the user it belongs to. this removes every reset
+// token the user has. if two requests race to use the same token only one of them gets a
+// user id back, and the other gets ErrInvalidToken
+func (m *PasswordResetModel) Consume(plaintext string) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	userID, err := m.UserID(plaintext)
+	if err != nil {
+		return 0, err
+	}
+
+	stmt := "DELETE FROM password_resets WHERE user_id = ? AND hash = ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), userID, hashToken(plaintext))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, ErrInvalidToken
+	}
+
+	// the token has been claimed, so any other outstanding tokens for the user can go too
+	stmt = "DELETE FROM password_resets WHERE user_id = ?"
+	if _, err := m.DB.Exec(d.Rebind(stmt), userID); err != nil {
+		return 0, err
+	}
+	return userID, nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"database/sql"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the methods we need from the sessions table. the table itself is owned by the scs
+// session store, so we only ever read from it
+type SessionModelInterface interface {
+	CountExpired() (int, error)
+}
+
+// define a SessionModel type which wraps a sql.DB connection pool. the Dialect
+// defaults to MySQL when it isn't set.
+type SessionModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// return the number of expired sessions which are still in the sessions table,
+// waiting for the session store's cleanup goroutine to delete them
+func (m *SessionModel) CountExpired() (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	var n int
+	stmt := "SELECT COUNT(*) FROM sessions WHERE expiry <= " + d.SessionNow()
+
+	err := m.DB.QueryRow(stmt).Scan(&n)
+	return n, err
+}
@@ -4,77 +4,93 @@ import (
 	"database/sql"
 	"errors"
 	"time"
+
+	"snippetbox.lets-go/internal/dialect"
 )
 
 // define struct to hold data for an individual snippet.
-// fields should
+// the struct tags set the field names used by the JSON API
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID       int       `json:"id"`
+	UserID   int       `json:"user_id"`   // id of the user who created the snippet
+	UserName string    `json:"user_name"` // name of the author, joined in from the users table
+	Title    string    `json:"title"`
+	Content  string    `json:"content"`
+	Created  time.Time `json:"created"`
+	Expires  time.Time `json:"expires"`
+}
+
+// the methods that handlers need from a snippet store. SnippetModel satisfies it,
+// and so do the mocks in the models/mocks package used by the handler tests
+type SnippetModelInterface interface {
+	Insert(userID int, title, content string, expires int) (int, error)
+	Get(id int) (*Snippet, error)
+	Latest(cursor Cursor) ([]*Snippet, Page, error)
+	ByUser(userID int) ([]*Snippet, error)
+	Search(query string, page int) ([]*Snippet, bool, error)
+	Update(id, userID int, title, content string, expires int) error
+	Delete(id int) error
+	DeleteExpired(limit int) (int, error)
 }
 
 // define a SnippetModel type which wraps a sql.DB connection pool.
+// the Dialect fills in the parts of the SQL which differ between databases, and
+// defaults to MySQL when it isn't set.
 type SnippetModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect dialect.Dialect
 }
 
-// this will insert a new snippet into the database.
-func (m *SnippetModel) Insert(title, content string, expires int) (int, error) {
+// this will insert a new snippet into the database, owned by the user with the given id.
+func (m *SnippetModel) Insert(userID int, title, content string, expires int) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
 
 	// SQL statement we want to run
 	stmt := `
 		INSERT INTO 
 			snippets (
-				title, content, created, expires
+				user_id, title, content, created, expires
 			)
 		VALUES(
-			?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY)
+			?, ?, ?, ` + d.Now() + `, ` + d.NowPlus("DAY") + `
 		);
 	`
 
-	result, err := m.DB.Exec(stmt, title, content, expires)
-	if err != nil {
-		return 0, err
-	}
-
-	// check LastInsertId() to get the ID of the newly inserted record
-	id, err := result.LastInsertId()
-	if err != nil {
-		return 0, err
-	}
-
-	// convert int64 to int type
-	return int(id), nil
+	// the dialect runs the statement and gets the ID of the newly inserted record back. MySQL
+	// and SQLite use LastInsertId() for this, while postgres needs a RETURNING clause
+	return d.Insert(m.DB, d.Rebind(stmt), userID, title, content, expires)
 }
 
 // this will return a specific snippet based on its id.
 func (m *SnippetModel) Get(id int) (*Snippet, error) {
+	d := dialect.OrDefault(m.Dialect)
 
-	// SQL statement to get specific id from database
+	// SQL statement to get specific id from database. we join against the users
+	// table so that the author's name comes back with the snippet
 	stmt := `
 		SELECT
-			id,
-		 	title,
-			content,
-			created,
-			expires
+			s.id,
+			s.user_id,
+			u.name,
+		 	s.title,
+			s.content,
+			s.created,
+			s.expires
 		FROM
-			snippets
+			snippets s
+			INNER JOIN users u ON u.id = s.user_id
 		WHERE
-			expires > UTC_TIMESTAMP() 
-			AND id = ?;
+			s.expires > ` + d.Now() + ` 
+			AND s.id = ?;
 	`
-	row := m.DB.QueryRow(stmt, id)
+	row := m.DB.QueryRow(d.Rebind(stmt), id)
 
 	// create a pointer to zeroed Snippet struct
 	s := &Snippet{}
 
 	// row.Scan() will copy the values from each field in sql.Row to the corresponding field in the Snippet struct.
 	// note that the arguments to row.Scan() are pointers to the place we want to copy the data into.
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	err := row.Scan(&s.ID, &s.UserID, &s.UserName, &s.Title, &s.Content, &s.Created, &s.Expires)
 	if err != nil {
 
 		// if the query returns no rows, then row.Scan() will return a sql.ErrNoRows error.
@@ -88,31 +104,117 @@ func (m *SnippetModel) Get(id int) (*Snippet, error) {
 	return s, nil
 }
 
-// this will return the 10 most recently created snippets.
-func (m *SnippetModel) Latest() ([]*Snippet, error) {
+// this will return one page of the most recently created snippets, newest first.
+// the cursor decides which page is returned (see Cursor), and the returned Page
+// says whether there are newer or older snippets either side of it.
+func (m *SnippetModel) Latest(cursor Cursor) ([]*Snippet, Page, error) {
+	d := dialect.OrDefault(m.Dialect)
+	limit := cursor.limit()
 
-	// write the SQL statement we want to execute
+	// by default we walk backwards from the newest snippet. when paging back towards newer
+	// snippets we have to walk forwards from the cursor instead, and reverse the results afterwards
+	where, order, arg := "", "DESC", 0
+	switch {
+	case cursor.Before > 0:
+		where, order, arg = "AND s.id > ?", "ASC", cursor.Before
+	case cursor.After > 0:
+		where, arg = "AND s.id < ?", cursor.After
+	}
+
+	// write the SQL statement we want to execute. we ask for one more row than the page
+	// size so that we can tell whether there is another page beyond this one
 	stmt := `
 		SELECT
-			id,
-			title,
-			content,
-			created,
-			expires 
+			s.id,
+			s.user_id,
+			u.name,
+			s.title,
+			s.content,
+			s.created,
+			s.expires 
 		FROM
-			snippets
+			snippets s
+			INNER JOIN users u ON u.id = s.user_id
 		WHERE 
-			expires > UTC_TIMESTAMP() 
+			s.expires > ` + d.Now() + ` 
+			` + where + `
 		ORDER BY 
-			id 
-		DESC LIMIT 10;
+			s.id ` + order + `
+		LIMIT ?;
 	`
 
+	args := []any{limit + 1}
+	if arg > 0 {
+		args = []any{arg, limit + 1}
+	}
+
 	// use Query() on the conn pool to execute our statement. This will return a sql.Rows resultset containing the result of our query.
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(d.Rebind(stmt), args...)
+	if err != nil {
+		return nil, Page{}, err
+	}
+	snippets, err := scanSnippets(rows)
+	if err != nil {
+		return nil, Page{}, err
+	}
+
+	more := len(snippets) > limit
+	if more {
+		snippets = snippets[:limit]
+	}
+
+	var page Page
+	if cursor.Before > 0 {
+		// put the page back into newest-first order
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+		page.HasNewer = more
+		page.HasOlder = true
+	} else {
+		page.HasNewer = cursor.After > 0
+		page.HasOlder = more
+	}
+
+	if len(snippets) > 0 {
+		page.NewestID = snippets[0].ID
+		page.OldestID = snippets[len(snippets)-1].ID
+	}
+	return snippets, page, nil
+}
+
+// this will return every unexpired snippet created by the user with the given id, newest first.
+func (m *SnippetModel) ByUser(userID int) ([]*Snippet, error) {
+	d := dialect.OrDefault(m.Dialect)
+	stmt := `
+		SELECT
+			s.id,
+			s.user_id,
+			u.name,
+			s.title,
+			s.content,
+			s.created,
+			s.expires 
+		FROM
+			snippets s
+			INNER JOIN users u ON u.id = s.user_id
+		WHERE 
+			s.expires > ` + d.Now() + ` 
+			AND s.user_id = ?
+		ORDER BY 
+			s.id DESC;
+	`
+
+	rows, err := m.DB.Query(d.Rebind(stmt), userID)
 	if err != nil {
 		return nil, err
 	}
+	return scanSnippets(rows)
+}
+
+// helper to copy every row in a snippets resultset into a slice of Snippet structs.
+// the rows are always closed before returning.
+func scanSnippets(rows *sql.Rows) ([]*Snippet, error) {
 
 	// defer rows.Close() after we check for an error so we do not attempt to close on a nil resultset
 	defer rows.Close()
@@ -126,7 +228,7 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 		s := &Snippet{}
 		// use rows.Scan() to copy the values from each field in teh row to our Snippet struct
 		// the arguments to row.Scan() must be pointers
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		err := rows.Scan(&s.ID, &s.UserID, &s.UserName, &s.Title, &s.Content, &s.Created, &s.Expires)
 		if err != nil {
 			return nil, err
 		}
@@ -134,8 +236,154 @@ func (m *SnippetModel) Latest() ([]*Snippet, error) {
 	}
 
 	// when the rows.Next() loop has finished, we call rows.Err() to retrieve any errors that were encountered during iteration
-	if err = rows.Err(); err != nil {
+	if err := rows.Err(); err != nil {
 		return nil, err
 	}
 	return snippets, nil
 }
+
+// the expires value for Update() which leaves the snippet's expiry as it is
+const KeepExpiry = 0
+
+// this will update the title, content and expiry of an unexpired snippet belonging to the
+// user with id userID. the expiry is recalculated from the current time, in the same way as
+// Insert(), unless expires is KeepExpiry. the ownership check is part of the UPDATE, so if the
+// snippet has gone, expired or belongs to somebody else ErrNoRecord is returned
+func (m *SnippetModel) Update(id, userID int, title, content string, expires int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	set := `title = ?, content = ?`
+	args := []any{title, content}
+	if expires != KeepExpiry {
+		set += `, expires = ` + d.NowPlus("DAY")
+		args = append(args, expires)
+	}
+	stmt := `UPDATE snippets SET ` + set + ` WHERE id = ? AND user_id = ? AND expires > ` + d.Now()
+	args = append(args, id, userID)
+
+	result, err := m.DB.Exec(d.Rebind(stmt), args...)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// mysql doesn't count rows which are left as they were, so when nothing changed we have
+	// to ask whether the snippet was there at all
+	if n == 0 {
+		var exists bool
+		stmt := `SELECT EXISTS(SELECT true FROM snippets WHERE id = ? AND user_id = ? AND expires > ` + d.Now() + `)`
+		err := m.DB.QueryRow(d.Rebind(stmt), id, userID).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+	return nil
+}
+
+// this will delete a specific snippet based on its id. if no snippet with
+// that id exists, ErrNoRecord is returned.
+func (m *SnippetModel) Delete(id int) error {
+	d := dialect.OrDefault(m.Dialect)
+	stmt := `DELETE FROM snippets WHERE id = ?;`
+
+	result, err := m.DB.Exec(d.Rebind(stmt), id)
+	if err != nil {
+		return err
+	}
+
+	// check RowsAffected() so that we can tell the caller when there was nothing to delete
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// this will return one page of the unexpired snippets matching a full-text search query,
+// with the most relevant first. pages are numbered from 1 and hold DefaultPageSize snippets.
+// the bool return value reports whether there are more results after this page.
+//
+// on MySQL the search relies on the FULLTEXT index over the title and content columns of the
+// snippets table, and on postgres the matching GIN index. see Dialect.TextMatch().
+func (m *SnippetModel) Search(query string, page int) ([]*Snippet, bool, error) {
+	d := dialect.OrDefault(m.Dialect)
+	if page < 1 {
+		page = 1
+	}
+	limit := DefaultPageSize
+
+	// relevance ordering doesn't give us a stable key to page on, so search results use
+	// plain LIMIT/OFFSET pagination instead of a Cursor. we fetch one extra row to find
+	// out whether there is another page
+	stmt := `
+		SELECT
+			s.id,
+			s.user_id,
+			u.name,
+			s.title,
+			s.content,
+			s.created,
+			s.expires
+		FROM
+			snippets s
+			INNER JOIN users u ON u.id = s.user_id
+		WHERE
+			s.expires > ` + d.Now() + `
+			AND ` + d.TextMatch("s.title", "s.content") + `
+		ORDER BY
+			` + d.TextRank("s.title", "s.content") + ` DESC,
+			s.id DESC
+		LIMIT ? OFFSET ?;
+	`
+
+	rows, err := m.DB.Query(d.Rebind(stmt), query, query, limit+1, (page-1)*limit)
+	if err != nil {
+		return nil, false, err
+	}
+	snippets, err := scanSnippets(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	more := len(snippets) > limit
+	if more {
+		snippets = snippets[:limit]
+	}
+	return snippets, more, nil
+}
+
+// this will permanently delete up to limit snippets which have expired, oldest first, and
+// return how many were deleted. callers wanting to clear everything should keep calling it
+// until it deletes fewer than limit rows, so that no single statement holds locks for long.
+func (m *SnippetModel) DeleteExpired(limit int) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	// postgres has no DELETE ... LIMIT, and MySQL won't accept a LIMIT in an IN subquery
+	// unless it is wrapped in a derived table, so we write it the one way all three accept
+	stmt := `
+		DELETE FROM
+			snippets
+		WHERE
+			id IN (
+				SELECT id FROM (
+					SELECT id FROM snippets WHERE expires <= ` + d.Now() + ` ORDER BY id LIMIT ?
+				) AS expired
+			);
+	`
+
+	result, err := m.DB.Exec(d.Rebind(stmt), limit)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
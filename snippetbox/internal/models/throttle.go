@@ -0,0 +1,128 @@
+package models
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the failures recorded against a throttle key, like an IP address which keeps getting
+// passwords wrong. the zero value means there are none
+type ThrottleEntry struct {
+	Failures int
+	Last     time.Time // when the latest failure happened
+}
+
+// a store of throttle entries. ThrottleModel keeps them in the database, so that every
+// instance of the app sees the same counts, and throttle.MemoryStore keeps them in memory.
+// times are passed in rather than read from the database's clock, so that tests can use
+// a fake clock
+type ThrottleModelInterface interface {
+	Get(key string, now time.Time) (ThrottleEntry, error)
+	Add(key string, now, expires time.Time) (ThrottleEntry, error)
+	Sub(key string) error
+	Delete(key string) error
+	DeleteExpired(now time.Time) (int, error)
+}
+
+// define a ThrottleModel type which wraps a sql.DB connection pool. the Dialect defaults
+// to MySQL when it isn't set.
+type ThrottleModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// the columns are only accurate to the second on some databases, so we round every time
+// we store to make all of them behave the same
+func throttleTime(t time.Time) time.Time {
+	return t.UTC().Truncate(time.Second)
+}
+
+// return the entry for key, or the zero entry if there isn't one which is still current at now
+func (m *ThrottleModel) Get(key string, now time.Time) (ThrottleEntry, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	var e ThrottleEntry
+	stmt := "SELECT failures, last_failure FROM throttle WHERE name = ? AND expires > ?"
+
+	err := m.DB.QueryRow(d.Rebind(stmt), key, throttleTime(now)).Scan(&e.Failures, &e.Last)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return ThrottleEntry{}, err
+	}
+	return e, nil
+}
+
+// record a failure for key at now, which is remembered until expires, and return the updated
+// entry. if the key's earlier failures have expired, the count starts again from one
+func (m *ThrottleModel) Add(key string, now, expires time.Time) (ThrottleEntry, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	now, expires = throttleTime(now), throttleTime(expires)
+
+	update := func() (int64, error) {
+		stmt := `
+			UPDATE throttle SET
+				failures = CASE WHEN expires <= ? THEN 1 ELSE failures + 1 END,
+				last_failure = ?,
+				expires = ?
+			WHERE name = ?
+		`
+		result, err := m.DB.Exec(d.Rebind(stmt), now, now, expires, key)
+		if err != nil {
+			return 0, err
+		}
+		return result.RowsAffected()
+	}
+
+	// there isn't a portable upsert, so we try to update the row first and insert it if it
+	// isn't there. if another request inserts it in between we lose the race on the primary
+	// key, and go back to updating the row they made
+	n, err := update()
+	if err != nil {
+		return ThrottleEntry{}, err
+	}
+	if n == 0 {
+		stmt := "INSERT INTO throttle (name, failures, last_failure, expires) VALUES (?, 1, ?, ?)"
+		_, err := m.DB.Exec(d.Rebind(stmt), key, now, expires)
+		// mysql calls a primary key PRIMARY, and postgres names it after the table
+		if d.IsUniqueViolation(err, "PRIMARY", "throttle.name") || d.IsUniqueViolation(err, "throttle_pkey", "throttle.name") {
+			_, err = update()
+		}
+		if err != nil {
+			return ThrottleEntry{}, err
+		}
+	}
+
+	return m.Get(key, now)
+}
+
+// take one failure off the count for key, when an attempt which was counted before it happened
+// turns out not to have failed. the time of the latest failure is left as it is
+func (m *ThrottleModel) Sub(key string) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	_, err := m.DB.Exec(d.Rebind("UPDATE throttle SET failures = failures - 1 WHERE name = ? AND failures > 0"), key)
+	return err
+}
+
+// forget the failures for key
+func (m *ThrottleModel) Delete(key string) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	_, err := m.DB.Exec(d.Rebind("DELETE FROM throttle WHERE name = ?"), key)
+	return err
+}
+
+// delete every entry which has expired at now, returning how many there were
+func (m *ThrottleModel) DeleteExpired(now time.Time) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	result, err := m.DB.Exec(d.Rebind("DELETE FROM throttle WHERE expires <= ?"), throttleTime(now))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
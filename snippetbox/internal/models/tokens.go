@@ -0,0 +1,225 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base32"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the scopes a token can be given. a read token can only be used to fetch snippets, and
+// a write token can also create, change and delete them
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// every token starts with this prefix, so that they are easy to recognise (and to search
+// for if one gets leaked)
+const TokenPrefix = "sbx_"
+
+// a personal API token. the plain-text token is only known when it is created. after
+// that we only keep a hash of it, so a token can't be shown again
+type Token struct {
+	ID      int
+	UserID  int
+	Name    string
+	Scopes  []string
+	Created time.Time
+	Expires time.Time // the zero time means the token never expires
+}
+
+// report whether the token has been given scope
+func (t *Token) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// the methods that handlers need from a token store
+type TokenModelInterface interface {
+	Insert(userID int, name string, scopes []string, expiresDays int) (string, error)
+	Authenticate(plaintext string) (*Token, error)
+	ForUser(userID int) ([]*Token, error)
+	Delete(userID, id int) error
+	DeleteAll(userID int) (int, error)
+}
+
+// define a TokenModel type which wraps a sql.DB connection pool. the Dialect
+// defaults to MySQL when it isn't set.
+type TokenModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// return a new random plain-text token. 20 random bytes give 160 bits of entropy, which
+// base32 encodes into 32 characters that are safe to paste anywhere, including URLs
+func generateToken() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)), nil
+}
+
+// return the hash we store for a plain-text token. the token is random and long, so a fast
+// hash like SHA-256 is enough. unlike passwords there is nothing to gain from bcrypt
+func hashToken(plaintext string) string {
+	sum := sha256.Sum256([]byte(plaintext))
+	return hex.EncodeToString(sum[:])
+}
+
+// create a new token for the user and return the plain-text token, which the caller must
+// show to the user straight away. expiresDays is how many days the token lasts, or zero
+// for a token which never expires
+func (m *TokenModel) Insert(userID int, name string, scopes []string, expiresDays int) (string, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	plaintext, err := generateToken()
+	if err != nil {
+		return "", err
+	}
+	plaintext = TokenPrefix + plaintext
+
+	// the expiry is worked out by the database, like the expiry of a snippet
+	expires, args := "NULL", []any{userID, name, hashToken(plaintext), strings.Join(scopes, " ")}
+	if expiresDays > 0 {
+		expires = d.NowPlus("DAY")
+		args = append(args, expiresDays)
+	}
+
+	stmt := `
+		INSERT INTO
+			tokens (
+				user_id, name, hash, scopes, created, expires
+			)
+		VALUES(
+			?, ?, ?, ?, ` + d.Now() + `, ` + expires + `
+		);
+	`
+	_, err = d.Insert(m.DB, d.Rebind(stmt), args...)
+	if err != nil {
+		return "", err
+	}
+	return plaintext, nil
+}
+
+// look up the token matching plaintext. if there is no such token, or it has
+// expired, we return ErrInvalidToken
+func (m *TokenModel) Authenticate(plaintext string) (*Token, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	if !strings.HasPrefix(plaintext, TokenPrefix) {
+		return nil, ErrInvalidToken
+	}
+
+	stmt := `
+		SELECT
+			id, user_id, name, scopes, created, expires
+		FROM
+			tokens
+		WHERE
+			hash = ?
+			AND (expires IS NULL OR expires > ` + d.Now() + `);
+	`
+	rows, err := m.DB.Query(d.Rebind(stmt), hashToken(plaintext))
+	if err != nil {
+		return nil, err
+	}
+	tokens, err := scanTokens(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, ErrInvalidToken
+	}
+	return tokens[0], nil
+}
+
+// return every token belonging to the user, including expired ones, newest first
+func (m *TokenModel) ForUser(userID int) ([]*Token, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := `
+		SELECT
+			id, user_id, name, scopes, created, expires
+		FROM
+			tokens
+		WHERE
+			user_id = ?
+		ORDER BY
+			id DESC;
+	`
+	rows, err := m.DB.Query(d.Rebind(stmt), userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTokens(rows)
+}
+
+// revoke a token. the user id has to match, so that users can only revoke their own
+// tokens. if there is no such token we return ErrNoRecord
+func (m *TokenModel) Delete(userID, id int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "DELETE FROM tokens WHERE id = ? AND user_id = ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// revoke every one of the user's tokens, and return how many there were
+func (m *TokenModel) DeleteAll(userID int) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	result, err := m.DB.Exec(d.Rebind("DELETE FROM tokens WHERE user_id = ?"), userID)
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// helper to copy every row in a tokens resultset into a slice of Token structs.
+// the rows are always closed before returning.
+func scanTokens(rows *sql.Rows) ([]*Token, error) {
+	defer rows.Close()
+
+	tokens := []*Token{}
+	for rows.Next() {
+		t := &Token{}
+		var scopes string
+
+		// expires is NULL for a token which never expires
+		var expires sql.NullTime
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &scopes, &t.Created, &expires)
+		if err != nil {
+			return nil, err
+		}
+		t.Scopes = strings.Fields(scopes)
+		t.Expires = expires.Time
+		tokens = append(tokens, t)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return tokens, nil
+}
@@ -0,0 +1,185 @@
+package models
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// how many recovery codes a user gets when they turn on two-factor authentication
+const RecoveryCodeCount = 10
+
+// the methods that handlers need from the store of two-factor authentication settings
+type TwoFactorModelInterface interface {
+	Enable(userID int, secret string) ([]string, error)
+	Disable(userID int) error
+	Secret(userID int) (string, error)
+	UseStep(userID int, step int64) (bool, error)
+	UseRecoveryCode(userID int, code string) (bool, error)
+	RecoveryCodesLeft(userID int) (int, error)
+}
+
+// define a TwoFactorModel type which wraps a sql.DB connection pool. a user's TOTP secret
+// lives on their row of the users table, and their recovery codes in the recovery_codes table.
+// the Dialect defaults to MySQL when it isn't set.
+type TwoFactorModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// return a new random recovery code, formatted like "abcd-efgh-ijkl-mnop" so that it is
+// easy to copy down. 10 random bytes give 80 bits, which is plenty for a one-time code
+// that we only keep a SHA-256 hash of
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+	return s[0:4] + "-" + s[4:8] + "-" + s[8:12] + "-" + s[12:16], nil
+}
+
+// return the form of a recovery code which we hash, so that it doesn't matter how the user
+// types the dashes, spaces or capitals
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// turn on two-factor authentication for the user with the given TOTP secret, and return a
+// fresh set of plain-text recovery codes, which the caller must show to the user straight
+// away. any recovery codes from before are thrown away
+func (m *TwoFactorModel) Enable(userID int, secret string) ([]string, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = code
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	// rolling back after a commit does nothing, so this only matters when we return early
+	defer tx.Rollback()
+
+	stmt := "UPDATE users SET totp_secret = ?, totp_last_step = 0 WHERE id = ?"
+	result, err := tx.Exec(d.Rebind(stmt), secret, userID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrNoRecord
+	}
+
+	if _, err := tx.Exec(d.Rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		return nil, err
+	}
+	for _, code := range codes {
+		stmt := "INSERT INTO recovery_codes (hash, user_id) VALUES (?, ?)"
+		if _, err := tx.Exec(d.Rebind(stmt), hashToken(normalizeRecoveryCode(code)), userID); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return codes, nil
+}
+
+// turn off two-factor authentication for the user, removing their secret and recovery codes
+func (m *TwoFactorModel) Disable(userID int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := "UPDATE users SET totp_secret = NULL, totp_last_step = 0 WHERE id = ?"
+	if _, err := tx.Exec(d.Rebind(stmt), userID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(d.Rebind("DELETE FROM recovery_codes WHERE user_id = ?"), userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// return the user's TOTP secret, or the empty string if they haven't turned on two-factor
+// authentication. if there is no such user we return ErrNoRecord
+func (m *TwoFactorModel) Secret(userID int) (string, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	var secret sql.NullString
+	stmt := "SELECT totp_secret FROM users WHERE id = ?"
+
+	err := m.DB.QueryRow(d.Rebind(stmt), userID).Scan(&secret)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", ErrNoRecord
+		}
+		return "", err
+	}
+	return secret.String, nil
+}
+
+// record that the user has logged in with the TOTP code for a time step. it returns false if
+// they have already used a code for this step or a later one, so that a code which has been
+// seen over somebody's shoulder can't be used a second time
+func (m *TwoFactorModel) UseStep(userID int, step int64) (bool, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "UPDATE users SET totp_last_step = ? WHERE id = ? AND totp_last_step < ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), step, userID, step)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// use up one of the user's recovery codes. it returns false if the code isn't one of theirs,
+// or has already been used
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) (bool, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), userID, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// return how many unused recovery codes the user has left
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	var n int
+	stmt := "SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?"
+	err := m.DB.QueryRow(d.Rebind(stmt), userID).Scan(&n)
+	return n, err
+}
@@ -3,11 +3,11 @@ package models
 import (
 	"database/sql"
 	"errors"
-	"strings"
+	"sync"
 	"time"
 
-	"github.com/go-sql-driver/mysql"
 	"golang.org/x/crypto/bcrypt"
+	"snippetbox.lets-go/internal/dialect"
 )
 
 // define a User type that has types that align with our database column types
@@ -17,56 +17,149 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	Activated      bool   // whether they have verified their email address
+	Role           string // one of RoleUser, RoleModerator or RoleAdmin
+	Disabled       bool   // disabled users can't log in, see AdminModel.SetUserDisabled()
 }
 
+// the roles a user can have. each role can do everything the roles before it can, so a
+// moderator is also a user, and an admin is also a moderator
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
+// the roles in order, from the least to the most powerful
+var Roles = []string{RoleUser, RoleModerator, RoleAdmin}
+
+// return the position of role in Roles, or -1 if it isn't a role
+func roleRank(role string) int {
+	for i, r := range Roles {
+		if r == role {
+			return i
+		}
+	}
+	return -1
+}
+
+// report whether role is one of the known roles
+func ValidRole(role string) bool {
+	return roleRank(role) >= 0
+}
+
+// report whether the user has role, or a role above it
+func (u *User) HasRole(role string) bool {
+	rank := roleRank(role)
+	return rank >= 0 && roleRank(u.Role) >= rank
+}
+
+// the methods that handlers need from a user store. UserModel satisfies it,
+// and so do the mocks in the models/mocks package used by the handler tests
+type UserModelInterface interface {
+	Insert(name, email, password string) (int, error)
+	Authenticate(email, password string) (int, error)
+	Exists(id int) (bool, error)
+	Get(id int) (*User, error)
+	PasswordUpdate(id int, currentPassword, newPassword string) error
+	PasswordReset(id int, newPassword string) error
+	GetByEmail(email string) (*User, error)
+	Activate(id int) error
+	RecordActivationSent(id int, intervalSeconds int) (bool, error)
+	SetRole(id int, role string) error
+}
+
+// the Dialect fills in the parts of the SQL which differ between databases, and
+// defaults to MySQL when it isn't set. BcryptCost defaults to DefaultBcryptCost.
 type UserModel struct {
-	DB *sql.DB
+	DB         *sql.DB
+	Dialect    dialect.Dialect
+	BcryptCost int
+}
+
+// bcrypt cost used when a UserModel doesn't set one. 12 is a sensible number
+const DefaultBcryptCost = 12
+
+// return the bcrypt cost to hash new passwords with
+func (um *UserModel) bcryptCost() int {
+	if um.BcryptCost == 0 {
+		return DefaultBcryptCost
+	}
+	return um.BcryptCost
 }
 
-// method to insert new record into our users table
-func (um *UserModel) Insert(name, email, password string) error {
+// a bcrypt hash for each cost, of a password nobody has, made the first time it is needed
+var dummyHashes sync.Map
+
+// return a hash with the same cost as new passwords get, for Authenticate() to check against
+// when there is no user with the email. that way the response takes about as long as it does
+// for a wrong password, and doesn't give away which emails have accounts
+func (um *UserModel) dummyHash() ([]byte, error) {
+	cost := um.bcryptCost()
+	if hash, ok := dummyHashes.Load(cost); ok {
+		return hash.([]byte), nil
+	}
 
-	// use cost of 12 which is a sensible number
-	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	hash, err := bcrypt.GenerateFromPassword([]byte("not a real password"), cost)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	dummyHashes.Store(cost, hash)
+	return hash, nil
+}
+
+// method to insert new record into our users table, returning the id of the new user.
+// new users start off unactivated until they verify their email address
+func (um *UserModel) Insert(name, email, password string) (int, error) {
+	d := dialect.OrDefault(um.Dialect)
+
+	// use the configured cost, which is 12 unless set otherwise
+	hashedPass, err := bcrypt.GenerateFromPassword([]byte(password), um.bcryptCost())
+	if err != nil {
+		return 0, err
 	}
 
 	stmt := `
 		INSERT INTO
 			users (name, email, hashed_password, created)
 		VALUES(
-			?, ?, ?, UTC_TIMESTAMP()
+			?, ?, ?, ` + d.Now() + `
 		)
 	`
-	// use the Exec()m ethod to insert the user details and hashed password into the users table
-	_, err = um.DB.Exec(stmt, name, email, string(hashedPass))
-	if err != nil {
-		// if this returns an error, we use errors.As() to check if it has a specific mysql error type
-		// if it does, the error will be assigned to the mySQLError variable. We can check whether
-		// or not the error relates to our users_uc_email key by checking if the error code equals 1062 and the contents of the error message string
-		// if it does we will return an ErrDuplicateEmail error
-		var mySQLError *mysql.MySQLError
-		if errors.As(err, &mySQLError) {
-			if mySQLError.Number == 1062 && strings.Contains(mySQLError.Message, "users_uc_email") {
-				return ErrDuplicateEmail
-			}
+	// use the dialect's Insert() method to insert the user details and hashed password into the users table
+	id, err := d.Insert(um.DB, d.Rebind(stmt), name, email, string(hashedPass))
+	if err != nil {
+		// if this returns an error, we ask the dialect whether it is a violation of our
+		// users_uc_email key. on MySQL that means checking if the error code equals 1062 and the
+		// contents of the error message string. if it is we will return an ErrDuplicateEmail error
+		if d.IsUniqueViolation(err, "users_uc_email", "users.email") {
+			return 0, ErrDuplicateEmail
 		}
-		return err
+		return 0, err
 	}
-	return nil
+	return id, nil
 }
 
 // method to authenticate to verify whether a user exists with the provided email
-// and password. this will return the relevant user ID if they do.
+// and password. this will return the relevant user ID if they do. if the password is right
+// but the user has been disabled we return ErrUserDisabled
 func (um *UserModel) Authenticate(email, password string) (int, error) {
+	d := dialect.OrDefault(um.Dialect)
+
 	var id int
 	var hashedPassword []byte
-	stmt := "SELECT id, hashed_password FROM users WHERE email =?"
+	var disabled bool
+	stmt := "SELECT id, hashed_password, disabled FROM users WHERE email =?"
 
-	err := um.DB.QueryRow(stmt, email).Scan(&id, &hashedPassword)
+	err := um.DB.QueryRow(d.Rebind(stmt), email).Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
+			// spend as long on an unknown email as we would on checking a password
+			hash, err := um.dummyHash()
+			if err != nil {
+				return 0, err
+			}
+			bcrypt.CompareHashAndPassword(hash, []byte(password))
 			return 0, ErrInvalidCredentials
 		} else {
 			return 0, err
@@ -83,16 +176,203 @@ func (um *UserModel) Authenticate(email, password string) (int, error) {
 			return 0, err
 		}
 	}
+
+	// we only say that the user is disabled once they have proved who they are, so that
+	// nobody else can find out
+	if disabled {
+		return 0, ErrUserDisabled
+	}
+
 	// otherwise the id is correct
 	return id, nil
 }
 
 // method to check if a user exists with a specific ID.
 func (um *UserModel) Exists(id int) (bool, error) {
+	d := dialect.OrDefault(um.Dialect)
+
 	var exists bool
 
-	stmt := "SELECT EXISTS(SELECT true FROM USERS WHERE id = ?)"
+	stmt := "SELECT EXISTS(SELECT true FROM users WHERE id = ?)"
 
-	err := um.DB.QueryRow(stmt, id).Scan(&exists)
+	err := um.DB.QueryRow(d.Rebind(stmt), id).Scan(&exists)
 	return exists, err
 }
+
+// method to fetch the details of the user with a specific ID. the hashed password is
+// left out, since nothing outside the model should need it
+func (um *UserModel) Get(id int) (*User, error) {
+	d := dialect.OrDefault(um.Dialect)
+
+	u := &User{}
+	stmt := "SELECT id, name, email, created, activated, role, disabled FROM users WHERE id = ?"
+
+	err := um.DB.QueryRow(d.Rebind(stmt), id).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.Role, &u.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// method to change a user's password. the current password is checked first, in the same
+// way as Authenticate() does, and if it doesn't match we return ErrInvalidCredentials
+func (um *UserModel) PasswordUpdate(id int, currentPassword, newPassword string) error {
+	d := dialect.OrDefault(um.Dialect)
+
+	var currentHashedPassword []byte
+	stmt := "SELECT hashed_password FROM users WHERE id = ?"
+
+	err := um.DB.QueryRow(d.Rebind(stmt), id).Scan(&currentHashedPassword)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrNoRecord
+		}
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(currentHashedPassword, []byte(currentPassword))
+	if err != nil {
+		if errors.Is(err, bcrypt.ErrMismatchedHashAndPassword) {
+			return ErrInvalidCredentials
+		}
+		return err
+	}
+
+	return um.setPassword(id, newPassword)
+}
+
+// method to set a new password for a user who has forgotten their current one. the caller
+// must already have checked that they are allowed to, with a password reset token
+func (um *UserModel) PasswordReset(id int, newPassword string) error {
+	return um.setPassword(id, newPassword)
+}
+
+// hash the new password and store it. if there is no such user we return ErrNoRecord
+func (um *UserModel) setPassword(id int, newPassword string) error {
+	d := dialect.OrDefault(um.Dialect)
+
+	newHashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword), um.bcryptCost())
+	if err != nil {
+		return err
+	}
+
+	stmt := "UPDATE users SET hashed_password = ? WHERE id = ?"
+	result, err := um.DB.Exec(d.Rebind(stmt), string(newHashedPassword), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// method to fetch the details of the user with a specific email address
+func (um *UserModel) GetByEmail(email string) (*User, error) {
+	d := dialect.OrDefault(um.Dialect)
+
+	u := &User{}
+	stmt := "SELECT id, name, email, created, activated, role, disabled FROM users WHERE email = ?"
+
+	err := um.DB.QueryRow(d.Rebind(stmt), email).Scan(&u.ID, &u.Name, &u.Email, &u.Created, &u.Activated, &u.Role, &u.Disabled)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNoRecord
+		} else {
+			return nil, err
+		}
+	}
+	return u, nil
+}
+
+// method to mark a user's email address as verified. activating a user twice is harmless.
+// if there is no such user we return ErrNoRecord
+func (um *UserModel) Activate(id int) error {
+	d := dialect.OrDefault(um.Dialect)
+
+	stmt := "UPDATE users SET activated = TRUE WHERE id = ? AND activated = FALSE"
+	result, err := um.DB.Exec(d.Rebind(stmt), id)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// nothing changed, either because the user was already activated or because they
+	// don't exist. mysql doesn't count rows which are left as they were, so we have to ask
+	if n == 0 {
+		exists, err := um.Exists(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+	return nil
+}
+
+// method to record that an activation email is about to be sent to a user. it returns false,
+// and records nothing, if the user is already activated or if the last email was sent less
+// than intervalSeconds ago. the check and the update happen in one statement, so two requests
+// at the same time can't both send an email
+func (um *UserModel) RecordActivationSent(id int, intervalSeconds int) (bool, error) {
+	d := dialect.OrDefault(um.Dialect)
+
+	stmt := `
+		UPDATE users SET activation_sent = ` + d.Now() + `
+		WHERE id = ? AND activated = FALSE
+		AND (activation_sent IS NULL OR activation_sent <= ` + d.NowPlus("SECOND") + `)
+	`
+	result, err := um.DB.Exec(d.Rebind(stmt), id, -intervalSeconds)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// method to change a user's role. it returns ErrInvalidRole if role isn't one of Roles, and
+// ErrNoRecord if there is no such user
+func (um *UserModel) SetRole(id int, role string) error {
+	d := dialect.OrDefault(um.Dialect)
+
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	stmt := "UPDATE users SET role = ? WHERE id = ? AND role <> ?"
+	result, err := um.DB.Exec(d.Rebind(stmt), role, id, role)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	// like in Activate(), nothing changing might just mean the user already had the role
+	if n == 0 {
+		exists, err := um.Exists(id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return ErrNoRecord
+		}
+	}
+	return nil
+}
@@ -0,0 +1,206 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+	"unicode/utf8"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the longest user agent we keep. anything longer is cut short, since it is only for showing
+// the user which device a session is on
+const MaxUserAgentLength = 255
+
+// a logged in session. the session data itself lives in the scs session store, which has no
+// idea which user a session belongs to, so we keep a row for every logged in session as well.
+// like API tokens we only store a hash of the session token, and a session without a row
+// isn't treated as logged in, which is how sessions are revoked
+type UserSession struct {
+	ID        int
+	UserID    int
+	UserAgent string
+	IP        string
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+}
+
+// the methods that handlers need from the store of logged in sessions
+type UserSessionModelInterface interface {
+	Insert(userID int, token, userAgent, ip string, lifetime time.Duration) error
+	Get(token string) (*UserSession, error)
+	Touch(id int, ip string) error
+	ForUser(userID int) ([]*UserSession, error)
+	Delete(userID, id int) error
+	DeleteByToken(token string) error
+	DeleteAllExcept(userID int, token string) (int, error)
+	DeleteExpired() (int, error)
+}
+
+// define a UserSessionModel type which wraps a sql.DB connection pool. the Dialect defaults
+// to MySQL when it isn't set.
+type UserSessionModel struct {
+	DB      *sql.DB
+	Dialect dialect.Dialect
+}
+
+// record that the session with the given token is logged in as the user. lifetime is how long
+// the session store keeps the session, after which the row is no use
+func (m *UserSessionModel) Insert(userID int, token, userAgent, ip string, lifetime time.Duration) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	// cut long user agents short, without leaving half a character on the end
+	if len(userAgent) > MaxUserAgentLength {
+		userAgent = userAgent[:MaxUserAgentLength]
+		for !utf8.ValidString(userAgent) {
+			userAgent = userAgent[:len(userAgent)-1]
+		}
+	}
+
+	stmt := `
+		INSERT INTO
+			user_sessions (
+				user_id, hash, user_agent, ip, created, last_seen, expires
+			)
+		VALUES(
+			?, ?, ?, ?, ` + d.Now() + `, ` + d.Now() + `, ` + d.NowPlus("SECOND") + `
+		);
+	`
+	_, err := d.Insert(m.DB, d.Rebind(stmt), userID, hashToken(token), userAgent, ip, int(lifetime/time.Second))
+	return err
+}
+
+// return the logged in session with the given token. if there isn't one, because the session
+// was never logged in, has been revoked or has expired, we return ErrNoRecord
+func (m *UserSessionModel) Get(token string) (*UserSession, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := `
+		SELECT
+			id, user_id, user_agent, ip, created, last_seen, expires
+		FROM
+			user_sessions
+		WHERE
+			hash = ?
+			AND expires > ` + d.Now() + `;
+	`
+	rows, err := m.DB.Query(d.Rebind(stmt), hashToken(token))
+	if err != nil {
+		return nil, err
+	}
+	sessions, err := scanUserSessions(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(sessions) == 0 {
+		return nil, ErrNoRecord
+	}
+	return sessions[0], nil
+}
+
+// record that the session has just been used from the IP address ip
+func (m *UserSessionModel) Touch(id int, ip string) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "UPDATE user_sessions SET last_seen = " + d.Now() + ", ip = ? WHERE id = ?"
+	_, err := m.DB.Exec(d.Rebind(stmt), ip, id)
+	return err
+}
+
+// return the user's logged in sessions which haven't expired, most recently used first
+func (m *UserSessionModel) ForUser(userID int) ([]*UserSession, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := `
+		SELECT
+			id, user_id, user_agent, ip, created, last_seen, expires
+		FROM
+			user_sessions
+		WHERE
+			user_id = ?
+			AND expires > ` + d.Now() + `
+		ORDER BY
+			last_seen DESC, id DESC;
+	`
+	rows, err := m.DB.Query(d.Rebind(stmt), userID)
+	if err != nil {
+		return nil, err
+	}
+	return scanUserSessions(rows)
+}
+
+// revoke one of the user's sessions. the user id has to match, so that users can only revoke
+// their own sessions. if there is no such session we return ErrNoRecord
+func (m *UserSessionModel) Delete(userID, id int) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "DELETE FROM user_sessions WHERE id = ? AND user_id = ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoRecord
+	}
+	return nil
+}
+
+// forget the session with the given token, when it logs out
+func (m *UserSessionModel) DeleteByToken(token string) error {
+	d := dialect.OrDefault(m.Dialect)
+
+	_, err := m.DB.Exec(d.Rebind("DELETE FROM user_sessions WHERE hash = ?"), hashToken(token))
+	return err
+}
+
+// revoke every one of the user's sessions apart from the one with the given token, and return
+// how many there were. an empty token revokes all of them
+func (m *UserSessionModel) DeleteAllExcept(userID int, token string) (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	stmt := "DELETE FROM user_sessions WHERE user_id = ? AND hash <> ?"
+	result, err := m.DB.Exec(d.Rebind(stmt), userID, hashToken(token))
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// delete the rows for sessions which have expired, returning how many there were
+func (m *UserSessionModel) DeleteExpired() (int, error) {
+	d := dialect.OrDefault(m.Dialect)
+
+	result, err := m.DB.Exec("DELETE FROM user_sessions WHERE expires <= " + d.Now())
+	if err != nil {
+		return 0, err
+	}
+	n, err := result.RowsAffected()
+	return int(n), err
+}
+
+// helper to copy every row in a user_sessions resultset into a slice of UserSession structs.
+// the rows are always closed before returning.
+func scanUserSessions(rows *sql.Rows) ([]*UserSession, error) {
+	defer rows.Close()
+
+	sessions := []*UserSession{}
+	for rows.Next() {
+		s := &UserSession{}
+		err := rows.Scan(&s.ID, &s.UserID, &s.UserAgent, &s.IP, &s.Created, &s.LastSeen, &s.Expires)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
@@ -0,0 +1,63 @@
+package signer
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+func newTestSigner(t *testing.T, key string) *Signer {
+	t.Helper()
+	s, err := New([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNew(t *testing.T) {
+	_, err := New([]byte("too short"))
+	assert.Equal(t, err != nil, true)
+}
+
+func TestSignAndVerify(t *testing.T) {
+	s := newTestSigner(t, strings.Repeat("k", MinKeyLength))
+
+	start := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	s.now = func() time.Time { return start }
+
+	token := s.Sign("activation", "1:alice@example.com", time.Hour)
+
+	value, err := s.Verify("activation", token)
+	assert.NilError(t, err)
+	assert.Equal(t, value, "1:alice@example.com")
+
+	tests := []struct {
+		name    string
+		purpose string
+		token   string
+		wantErr error
+	}{
+		{"Other purpose", "password_reset", token, ErrInvalidToken},
+		{"Tampered signature", "activation", token[:len(token)-1] + "x", ErrInvalidToken},
+		{"Tampered value", "activation", "Mjphb" + token[5:], ErrInvalidToken},
+		{"Other key", "activation", newTestSigner(t, strings.Repeat("x", MinKeyLength)).Sign("activation", "1:alice@example.com", time.Hour), ErrInvalidToken},
+		{"Empty", "activation", "", ErrInvalidToken},
+		{"Garbage", "activation", "a.b.c", ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.Verify(tt.purpose, tt.token)
+			assert.Equal(t, errors.Is(err, tt.wantErr), true)
+		})
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		s.now = func() time.Time { return start.Add(time.Hour) }
+		_, err := s.Verify("activation", token)
+		assert.Equal(t, errors.Is(err, ErrExpiredToken), true)
+	})
+}
@@ -0,0 +1,197 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+
+	"snippetbox.lets-go/internal/models"
+)
+
+// Policy says how hard to throttle a key as its failures add up. the first FreeAttempts
+// failures cost nothing. after that each failure makes the key wait before its next attempt,
+// starting at BaseDelay and doubling every time up to MaxDelay. once there have been
+// LockoutAttempts failures the key is locked out for Lockout. failures are forgotten after
+// Lockout has passed without another one
+type Policy struct {
+	FreeAttempts    int
+	BaseDelay       time.Duration
+	MaxDelay        time.Duration
+	LockoutAttempts int
+	Lockout         time.Duration
+}
+
+// Limiter applies a Policy to the keys in a store
+type Limiter struct {
+	store  models.ThrottleModelInterface
+	policy Policy
+	now    func() time.Time
+}
+
+// create a Limiter which counts failures in store. now is the clock to use, which is
+// normally time.Now
+func New(store models.ThrottleModelInterface, policy Policy, now func() time.Time) *Limiter {
+	return &Limiter{store: store, policy: policy, now: now}
+}
+
+// return how long key has to wait before its next attempt, or zero if it can go ahead now
+func (l *Limiter) RetryAfter(key string) (time.Duration, error) {
+	now := l.now()
+
+	e, err := l.store.Get(key, now)
+	if err != nil {
+		return 0, err
+	}
+
+	wait := e.Last.Add(l.policy.delay(e.Failures)).Sub(now)
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// count an attempt by key which is about to happen, like a password check, and return how long
+// key has to wait if it shouldn't go ahead, or zero if it can. unlike calling RetryAfter and
+// then Fail once the attempt has failed, it can't be raced: the attempt is counted in the store
+// before anything is checked, so when several requests for the same key arrive together each of
+// them sees a different count. while key still has free attempts all of them go ahead, and after
+// that at most one does, however many there are. an attempt which was refused because key was
+// already waiting isn't counted
+func (l *Limiter) Attempt(key string) (time.Duration, error) {
+	now := l.now()
+
+	before, err := l.store.Get(key, now)
+	if err != nil {
+		return 0, err
+	}
+	if wait := before.Last.Add(l.policy.delay(before.Failures)).Sub(now); wait > 0 {
+		return wait, nil
+	}
+
+	e, err := l.store.Add(key, now, now.Add(l.policy.Lockout))
+	if err != nil {
+		return 0, err
+	}
+
+	// if the count has gone up by more than our own attempt, other requests got in between
+	// the Get and the Add. only the request which made the count one more than it was can go
+	// ahead, and it may be that none of them can, since the database store reads the count
+	// back after updating it. being throttled a little too hard is fine here
+	if e.Failures > l.policy.FreeAttempts && e.Failures != before.Failures+1 {
+		return l.policy.delay(e.Failures), nil
+	}
+	return 0, nil
+}
+
+// record a failed attempt by key
+func (l *Limiter) Fail(key string) error {
+	now := l.now()
+	_, err := l.store.Add(key, now, now.Add(l.policy.Lockout))
+	return err
+}
+
+// take back an attempt counted by Attempt which turned out to succeed, so that it doesn't count
+// towards key's wait. unlike Reset, the earlier failures are still counted
+func (l *Limiter) Undo(key string) error {
+	return l.store.Sub(key)
+}
+
+// forget the failures for key, after it has succeeded
+func (l *Limiter) Reset(key string) error {
+	return l.store.Delete(key)
+}
+
+// return how long to wait after the latest of n failures
+func (p Policy) delay(n int) time.Duration {
+	switch {
+	case n >= p.LockoutAttempts:
+		return p.Lockout
+	case n <= p.FreeAttempts:
+		return 0
+	}
+
+	d := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < n && d < p.MaxDelay; i++ {
+		d *= 2
+	}
+	if d > p.MaxDelay {
+		d = p.MaxDelay
+	}
+	return d
+}
+
+// MemoryStore keeps throttle entries in memory. it is fine for a single instance of the app,
+// but the counts are lost on restart and aren't shared between instances, so anything bigger
+// should use the database with models.ThrottleModel
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+}
+
+type memoryEntry struct {
+	models.ThrottleEntry
+	expires time.Time
+}
+
+// create a new, empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]memoryEntry{}}
+}
+
+func (s *MemoryStore) Get(key string, now time.Time) (models.ThrottleEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !e.expires.After(now) {
+		return models.ThrottleEntry{}, nil
+	}
+	return e.ThrottleEntry, nil
+}
+
+func (s *MemoryStore) Add(key string, now, expires time.Time) (models.ThrottleEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.entries[key]
+	if !ok || !e.expires.After(now) {
+		e = memoryEntry{}
+	}
+	e.Failures++
+	e.Last = now
+	e.expires = expires
+	s.entries[key] = e
+	return e.ThrottleEntry, nil
+}
+
+func (s *MemoryStore) Sub(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.Failures > 0 {
+		e.Failures--
+		s.entries[key] = e
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
+
+func (s *MemoryStore) DeleteExpired(now time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var n int
+	for key, e := range s.entries {
+		if !e.expires.After(now) {
+			delete(s.entries, key)
+			n++
+		}
+	}
+	return n, nil
+}
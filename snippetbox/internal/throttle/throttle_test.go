@@ -0,0 +1,192 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+	"snippetbox.lets-go/internal/models"
+)
+
+var testPolicy = Policy{
+	FreeAttempts:    3,
+	BaseDelay:       time.Second,
+	MaxDelay:        10 * time.Second,
+	LockoutAttempts: 10,
+	Lockout:         15 * time.Minute,
+}
+
+func TestPolicyDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{6, 4 * time.Second},
+		{7, 8 * time.Second},
+		{8, 10 * time.Second},
+		{9, 10 * time.Second},
+		{10, 15 * time.Minute},
+		{50, 15 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, testPolicy.delay(tt.failures), tt.want)
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	l := New(store, testPolicy, func() time.Time { return now })
+
+	fail := func(n int) {
+		t.Helper()
+		for i := 0; i < n; i++ {
+			assert.NilError(t, l.Fail("email:alice@example.com"))
+		}
+	}
+	retryAfter := func() time.Duration {
+		t.Helper()
+		wait, err := l.RetryAfter("email:alice@example.com")
+		assert.NilError(t, err)
+		return wait
+	}
+
+	fail(3)
+	assert.Equal(t, retryAfter(), time.Duration(0))
+
+	fail(2)
+	assert.Equal(t, retryAfter(), 2*time.Second)
+
+	// the wait counts down from the latest failure
+	now = now.Add(1500 * time.Millisecond)
+	assert.Equal(t, retryAfter(), 500*time.Millisecond)
+
+	// other keys are unaffected
+	wait, err := l.RetryAfter("email:bob@example.com")
+	assert.NilError(t, err)
+	assert.Equal(t, wait, time.Duration(0))
+
+	fail(5)
+	assert.Equal(t, retryAfter(), 15*time.Minute)
+
+	// the lockout ends, and the failures are forgotten, once it has run its course
+	now = now.Add(15 * time.Minute)
+	assert.Equal(t, retryAfter(), time.Duration(0))
+	fail(1)
+	assert.Equal(t, retryAfter(), time.Duration(0))
+
+	fail(5)
+	assert.NilError(t, l.Reset("email:alice@example.com"))
+	assert.Equal(t, retryAfter(), time.Duration(0))
+
+	n, err := store.DeleteExpired(now.Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 0)
+	fail(1)
+	n, err = store.DeleteExpired(now.Add(time.Hour))
+	assert.NilError(t, err)
+	assert.Equal(t, n, 1)
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(), testPolicy, func() time.Time { return now })
+
+	attempt := func() time.Duration {
+		t.Helper()
+		wait, err := l.Attempt("email:alice@example.com")
+		assert.NilError(t, err)
+		return wait
+	}
+
+	// the free attempts go ahead, and then each one has to wait for the one before
+	for i := 0; i < 4; i++ {
+		assert.Equal(t, attempt(), time.Duration(0))
+	}
+	assert.Equal(t, attempt(), time.Second)
+
+	// attempts which are refused while waiting aren't counted
+	assert.Equal(t, attempt(), time.Second)
+	now = now.Add(time.Second)
+	assert.Equal(t, attempt(), time.Duration(0))
+	assert.Equal(t, attempt(), 2*time.Second)
+
+	// an attempt which is taken back doesn't count towards the wait
+	now = now.Add(2 * time.Second)
+	assert.Equal(t, attempt(), time.Duration(0))
+	assert.NilError(t, l.Undo("email:alice@example.com"))
+	assert.Equal(t, attempt(), 2*time.Second)
+
+	// a success resets the count
+	assert.NilError(t, l.Reset("email:alice@example.com"))
+	assert.Equal(t, attempt(), time.Duration(0))
+}
+
+// a store where every Get is followed by other attempts for the same key, like requests racing
+// in between the Limiter's Get and Add
+type racingStore struct {
+	*MemoryStore
+	racers int
+}
+
+func (s *racingStore) Get(key string, now time.Time) (models.ThrottleEntry, error) {
+	e, err := s.MemoryStore.Get(key, now)
+	for i := 0; i < s.racers; i++ {
+		s.MemoryStore.Add(key, now, now.Add(time.Hour))
+	}
+	return e, err
+}
+
+func TestLimiterAttemptRace(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	store := &racingStore{MemoryStore: NewMemoryStore()}
+	l := New(store, testPolicy, func() time.Time { return now })
+
+	// with free attempts left, racing doesn't matter
+	store.racers = 1
+	wait, err := l.Attempt("ip:192.0.2.1")
+	assert.NilError(t, err)
+	assert.Equal(t, wait, time.Duration(0))
+
+	// but once they have run out, the attempt which lost the race is refused
+	wait, err = l.Attempt("ip:192.0.2.1")
+	assert.NilError(t, err)
+	assert.Equal(t, wait, time.Second)
+}
+
+func TestLimiterAttemptConcurrent(t *testing.T) {
+	now := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(), testPolicy, func() time.Time { return now })
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.Attempt("email:alice@example.com")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				allowed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	// the free attempts, and at most one more
+	assert.Equal(t, allowed >= testPolicy.FreeAttempts && allowed <= testPolicy.FreeAttempts+1, true)
+}
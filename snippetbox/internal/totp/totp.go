@@ -0,0 +1,120 @@
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// the parameters we use for every secret. they are the defaults which authenticator apps
+// assume, so the otpauth:// URI doesn't strictly need to spell them out
+const (
+	Digits = 6
+	Period = 30 * time.Second
+
+	// how many steps either side of the current one we accept, to allow for clock drift
+	// between the server and the user's phone
+	Skew = 1
+)
+
+// error if a secret isn't valid base32
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// secrets are base32 without padding, which is what authenticator apps expect
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// return a new random secret. it is 160 bits long, as RFC 4226 recommends for HMAC-SHA1
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// return the number of the time step which t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// return the code for secret at time t
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// check code against secret at time t, allowing Skew steps of drift either way. when the code
+// matches, the step it belongs to is returned so that the caller can refuse to accept the
+// same step twice. spaces in the code are ignored, since apps often show codes as "123 456"
+func Validate(secret, code string, t time.Time) (step int64, ok bool) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	code = strings.ReplaceAll(code, " ", "")
+	if len(code) != Digits {
+		return 0, false
+	}
+
+	current := Step(t)
+	for s := current - Skew; s <= current+Skew; s++ {
+		if subtle.ConstantTimeCompare([]byte(hotp(key, uint64(s), Digits)), []byte(code)) == 1 {
+			return s, true
+		}
+	}
+	return 0, false
+}
+
+// return the otpauth:// URI which authenticator apps read from a QR code to set up an account.
+// the format is described at https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + v.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	key, err := encoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// the HOTP algorithm from RFC 4226: an HMAC-SHA1 of the counter, dynamically truncated to
+// a number with the given count of digits
+func hotp(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
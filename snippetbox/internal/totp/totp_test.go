@@ -0,0 +1,89 @@
+package totp
+
+import (
+	"encoding/base32"
+	"net/url"
+	"testing"
+	"time"
+
+	"snippetbox.lets-go/internal/assert"
+)
+
+// the SHA1 test vectors from appendix B of RFC 6238, which use 8 digit codes
+func TestHOTPVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, tt := range tests {
+		step := Step(time.Unix(tt.unix, 0))
+		assert.Equal(t, hotp(key, uint64(step), 8), tt.want)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+
+	code, err := Code(secret, now)
+	assert.NilError(t, err)
+	assert.Equal(t, code, "050471")
+
+	step, ok := Validate(secret, code, now)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, step, Step(now))
+
+	// codes from one step either side are accepted, to allow for clock drift
+	_, ok = Validate(secret, code, now.Add(Period))
+	assert.Equal(t, ok, true)
+	_, ok = Validate(secret, code, now.Add(-Period))
+	assert.Equal(t, ok, true)
+
+	// but not from further away
+	_, ok = Validate(secret, code, now.Add(2*Period))
+	assert.Equal(t, ok, false)
+
+	_, ok = Validate(secret, "050 471", now)
+	assert.Equal(t, ok, true)
+	_, ok = Validate(secret, "050472", now)
+	assert.Equal(t, ok, false)
+	_, ok = Validate(secret, "", now)
+	assert.Equal(t, ok, false)
+	_, ok = Validate("not base32!", code, now)
+	assert.Equal(t, ok, false)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	a, err := GenerateSecret()
+	assert.NilError(t, err)
+	b, err := GenerateSecret()
+	assert.NilError(t, err)
+
+	assert.Equal(t, len(a), 32)
+	assert.Equal(t, a != b, true)
+
+	_, err = Code(a, time.Now())
+	assert.NilError(t, err)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Snippetbox", "alice@example.com", "JBSWY3DPEHPK3PXP")
+
+	u, err := url.Parse(uri)
+	assert.NilError(t, err)
+	assert.Equal(t, u.Scheme, "otpauth")
+	assert.Equal(t, u.Host, "totp")
+	assert.Equal(t, u.Path, "/Snippetbox:alice@example.com")
+	assert.Equal(t, u.Query().Get("secret"), "JBSWY3DPEHPK3PXP")
+	assert.Equal(t, u.Query().Get("issuer"), "Snippetbox")
+}
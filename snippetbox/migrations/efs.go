@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+
+	"snippetbox.lets-go/internal/dialect"
+)
+
+// the versioned schema migrations, embedded into the binary in the same way as ui.Files.
+// there is one directory of migrations for each database dialect, and each migration is a
+// pair of files named <version>_<name>.up.sql and <version>_<name>.down.sql
+//
+//go:embed "mysql" "postgres" "sqlite"
+var Files embed.FS
+
+// return the migrations for dialect d
+func For(d dialect.Dialect) (fs.FS, error) {
+	return fs.Sub(Files, d.Name())
+}
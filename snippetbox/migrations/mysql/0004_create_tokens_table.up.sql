@@ -0,0 +1,12 @@
+CREATE TABLE tokens (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    scopes VARCHAR(100) NOT NULL,
+    created DATETIME NOT NULL,
+    expires DATETIME NULL,
+    CONSTRAINT tokens_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_uc_hash UNIQUE (hash);
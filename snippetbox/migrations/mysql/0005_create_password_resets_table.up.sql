@@ -0,0 +1,6 @@
+CREATE TABLE password_resets (
+    hash CHAR(64) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT password_resets_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
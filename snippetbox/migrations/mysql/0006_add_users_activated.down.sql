@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN activation_sent;
+ALTER TABLE users DROP COLUMN activated;
@@ -0,0 +1,8 @@
+ALTER TABLE users ADD totp_secret VARCHAR(64) NULL;
+ALTER TABLE users ADD totp_last_step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    hash CHAR(64) NOT NULL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    CONSTRAINT recovery_codes_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
@@ -0,0 +1,8 @@
+CREATE TABLE throttle (
+    name VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_throttle_expires ON throttle (expires);
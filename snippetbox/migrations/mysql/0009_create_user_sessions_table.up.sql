@@ -0,0 +1,13 @@
+CREATE TABLE user_sessions (
+    id INTEGER NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INTEGER NOT NULL,
+    hash CHAR(64) NOT NULL,
+    user_agent VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expires DATETIME NOT NULL,
+    CONSTRAINT user_sessions_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE user_sessions ADD CONSTRAINT user_sessions_uc_hash UNIQUE (hash);
@@ -0,0 +1,15 @@
+CREATE TABLE snippets (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created TIMESTAMP NOT NULL,
+    expires TIMESTAMP NOT NULL,
+    CONSTRAINT snippets_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_snippets_created ON snippets (created);
+
+CREATE INDEX idx_snippets_expires ON snippets (expires);
+
+CREATE INDEX snippets_fulltext ON snippets USING GIN (to_tsvector('english', title || ' ' || content));
@@ -0,0 +1,12 @@
+CREATE TABLE tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    hash CHAR(64) NOT NULL,
+    scopes VARCHAR(100) NOT NULL,
+    created TIMESTAMP NOT NULL,
+    expires TIMESTAMP NULL,
+    CONSTRAINT tokens_fk_user FOREIGN KEY (user_id) REFERENCES users (id) ON DELETE CASCADE
+);
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_uc_hash UNIQUE (hash);
@@ -0,0 +1,8 @@
+CREATE TABLE throttle (
+    name VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL,
+    last_failure TIMESTAMP NOT NULL,
+    expires TIMESTAMP NOT NULL
+);
+
+CREATE INDEX idx_throttle_expires ON throttle (expires);
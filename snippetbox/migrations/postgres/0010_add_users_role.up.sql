@@ -0,0 +1,4 @@
+-- every user starts with the plain user role. admins can change anybody else's role on the
+-- /admin/users page, but to make the first admin, run something like
+-- UPDATE users SET role = 'admin' WHERE email = 'alice@example.com';
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user';
@@ -0,0 +1,2 @@
+-- disabled users can't log in or use their API tokens. admins turn it on and off from /admin/users
+ALTER TABLE users ADD COLUMN disabled BOOLEAN NOT NULL DEFAULT FALSE;
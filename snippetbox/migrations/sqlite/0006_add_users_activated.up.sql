@@ -0,0 +1,5 @@
+ALTER TABLE users ADD COLUMN activated BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN activation_sent DATETIME NULL;
+
+-- accounts which existed before email verification was introduced stay usable
+UPDATE users SET activated = TRUE;
@@ -0,0 +1,7 @@
+ALTER TABLE users ADD COLUMN totp_secret TEXT NULL;
+ALTER TABLE users ADD COLUMN totp_last_step INTEGER NOT NULL DEFAULT 0;
+
+CREATE TABLE recovery_codes (
+    hash TEXT PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
@@ -4,5 +4,8 @@ import (
 	"embed"
 )
 
-//go:embed "html" "static"
+// the html and static folders hold the web pages, and the email folder holds the
+// templates for the emails sent by the mailer package
+//
+//go:embed "html" "static" "email"
 var Files embed.FS